@@ -0,0 +1,134 @@
+// Package apperror defines the errors services and repositories return to callers. Every error has a kind, which
+// decides the HTTP status, and a stable code clients can branch on. Causes are kept for logs but never rendered.
+package apperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+type Kind string
+
+const (
+	KindInvalid            Kind = "invalid"
+	KindUnauthorized       Kind = "unauthorized"
+	KindForbidden          Kind = "forbidden"
+	KindNotFound           Kind = "not_found"
+	KindMethodNotAllowed   Kind = "method_not_allowed"
+	KindConflict           Kind = "conflict"
+	KindGone               Kind = "gone"
+	KindTooLarge           Kind = "too_large"
+	KindUnsupportedType    Kind = "unsupported_type"
+	KindRangeNotSatisfied  Kind = "range_not_satisfiable"
+	KindQuotaExceeded      Kind = "quota_exceeded"
+	KindStorageUnavailable Kind = "storage_unavailable"
+	KindNotImplemented     Kind = "not_implemented"
+	KindInternal           Kind = "internal"
+)
+
+var kindStatus = map[Kind]int{
+	KindInvalid:            http.StatusBadRequest,
+	KindUnauthorized:       http.StatusUnauthorized,
+	KindForbidden:          http.StatusForbidden,
+	KindNotFound:           http.StatusNotFound,
+	KindMethodNotAllowed:   http.StatusMethodNotAllowed,
+	KindConflict:           http.StatusConflict,
+	KindGone:               http.StatusGone,
+	KindTooLarge:           http.StatusRequestEntityTooLarge,
+	KindUnsupportedType:    http.StatusUnsupportedMediaType,
+	KindRangeNotSatisfied:  http.StatusRequestedRangeNotSatisfiable,
+	KindQuotaExceeded:      http.StatusInsufficientStorage,
+	KindStorageUnavailable: http.StatusServiceUnavailable,
+	KindNotImplemented:     http.StatusNotImplemented,
+	KindInternal:           http.StatusInternalServerError,
+}
+
+// Status returns the HTTP status of the kind
+func (kind Kind) Status() int {
+	status, ok := kindStatus[kind]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// Error is an error with a kind and a stable code. Errors with the same code match with errors.Is, so a sentinel
+// still matches after WithMessage or Wrap.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string //safe to show to clients
+	Err     error  //cause, for logs only
+}
+
+func New(kind Kind, code string, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	other, ok := target.(*Error)
+	return ok && other.Code == e.Code
+}
+
+// WithMessage returns a copy of the error with a more specific message
+func (e *Error) WithMessage(message string) *Error {
+	copied := *e
+	copied.Message = message
+	return &copied
+}
+
+// Wrap returns a copy of the error carrying the cause
+func (e *Error) Wrap(cause error) *Error {
+	copied := *e
+	copied.Err = cause
+	return &copied
+}
+
+// Invalid is a bad request, e.g. a missing or malformed parameter
+func Invalid(code string, message string) *Error {
+	return New(KindInvalid, code, message)
+}
+
+func NotFound(code string, message string) *Error {
+	return New(KindNotFound, code, message)
+}
+
+// Internal hides the cause from clients behind the message
+func Internal(message string, cause error) *Error {
+	return &Error{Kind: KindInternal, Code: "internal_error", Message: message, Err: cause}
+}
+
+// StorageUnavailable is a failure of the blob store, which is usually transient
+func StorageUnavailable(message string, cause error) *Error {
+	return &Error{Kind: KindStorageUnavailable, Code: "storage_unavailable", Message: message, Err: cause}
+}
+
+// From returns the error as an *Error, errors without a kind are internal errors. An internal error caused by
+// an unavailable dependency is reported as unavailable, so clients know to retry.
+func From(err error) *Error {
+	var appErr *Error
+	if !errors.As(err, &appErr) {
+		return Internal("something went wrong", err)
+	}
+	var cause *Error
+	if appErr.Kind == KindInternal && errors.As(appErr.Err, &cause) && cause.Kind == KindStorageUnavailable {
+		return &Error{Kind: cause.Kind, Code: cause.Code, Message: appErr.Message, Err: appErr.Err}
+	}
+	return appErr
+}
+
+// KindOf returns the kind of the error, KindInternal for errors without one
+func KindOf(err error) Kind {
+	return From(err).Kind
+}
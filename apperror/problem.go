@@ -0,0 +1,44 @@
+package apperror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body, Code is an extension member carrying the stable error code
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// ToProblem renders the error for the request. Internal errors only show their message, never the cause.
+func ToProblem(err error, r *http.Request) Problem {
+	appErr := From(err)
+	status := appErr.Kind.Status()
+	problem := Problem{
+		Type:   "/problems/" + appErr.Code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: appErr.Message,
+		Code:   appErr.Code,
+	}
+	if r != nil {
+		problem.Instance = r.URL.Path
+	}
+	return problem
+}
+
+// Write writes the error as a problem+json response
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	problem := ToProblem(err, r)
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+)
+
+const (
+	MethodOIDC   = "oidc"
+	MethodAPIKey = "api_key"
+	MethodStatic = "static_token"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries no credential it understands,
+// the middleware then moves on to the next one
+var ErrNoCredentials = errors.New("no credentials for this authenticator")
+
+// Authenticator resolves the credentials of a request to a principal. The user id is left for the caller to resolve,
+// authenticators only vouch for the email.
+type Authenticator interface {
+	Authenticate(r *http.Request) (identity.Principal, error)
+}
+
+// APIKeyVerifier resolves an API key to the email of the user it was issued to and the scopes it is limited to
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, key string) (string, []string, error)
+}
+
+// OIDCAuthenticator accepts ID tokens as bearer tokens
+type OIDCAuthenticator struct {
+	validator *TokenValidator
+}
+
+func NewOIDCAuthenticator(validator *TokenValidator) *OIDCAuthenticator {
+	return &OIDCAuthenticator{validator: validator}
+}
+
+func (authenticator *OIDCAuthenticator) Authenticate(r *http.Request) (identity.Principal, error) {
+	token, ok := bearerToken(r)
+	//anything that is not a compact JWS is left for the other authenticators
+	if !ok || strings.Count(token, ".") != 2 {
+		return identity.Principal{}, ErrNoCredentials
+	}
+
+	claims, err := authenticator.validator.Validate(r.Context(), token)
+	if err != nil {
+		return identity.Principal{}, err
+	}
+	return identity.Principal{
+		Email:      claims.Email,
+		AuthMethod: MethodOIDC,
+		Tenant:     claims.HostedDomain,
+	}, nil
+}
+
+// APIKeyAuthenticator accepts long lived API keys sent in the X-API-Key header
+type APIKeyAuthenticator struct {
+	verifier APIKeyVerifier
+}
+
+func NewAPIKeyAuthenticator(verifier APIKeyVerifier) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{verifier: verifier}
+}
+
+func (authenticator *APIKeyAuthenticator) Authenticate(r *http.Request) (identity.Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if len(key) == 0 {
+		return identity.Principal{}, ErrNoCredentials
+	}
+
+	email, scopes, err := authenticator.verifier.VerifyAPIKey(r.Context(), key)
+	if err != nil {
+		return identity.Principal{}, err
+	}
+	return identity.Principal{Email: email, AuthMethod: MethodAPIKey, Scopes: scopes}, nil
+}
+
+// StaticTokenAuthenticator maps fixed bearer tokens to emails. Meant for local development only.
+type StaticTokenAuthenticator struct {
+	tokens map[string]string
+}
+
+func NewStaticTokenAuthenticator(tokens map[string]string) *StaticTokenAuthenticator {
+	return &StaticTokenAuthenticator{tokens: tokens}
+}
+
+func (authenticator *StaticTokenAuthenticator) Authenticate(r *http.Request) (identity.Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return identity.Principal{}, ErrNoCredentials
+	}
+
+	for staticToken, email := range authenticator.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(staticToken)) == 1 {
+			return identity.Principal{Email: email, AuthMethod: MethodStatic}, nil
+		}
+	}
+	return identity.Principal{}, ErrNoCredentials
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return "", false
+	}
+	return parts[1], true
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// minimum time between refreshes triggered by tokens carrying an unknown key id, so such tokens cannot hammer the issuer
+const unknownKeyRefreshBackoff = time.Minute
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// JWKS caches the signing keys of an issuer, loaded either from a URL that is refreshed periodically or from a local file
+type JWKS struct {
+	url         string
+	file        string
+	client      *http.Client
+	logger      *zap.Logger
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+}
+
+func NewRemoteJWKS(logger *zap.Logger, url string) *JWKS {
+	return &JWKS{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		keys:   map[string]crypto.PublicKey{},
+	}
+}
+
+func NewFileJWKS(logger *zap.Logger, file string) *JWKS {
+	return &JWKS{
+		file:   file,
+		logger: logger,
+		keys:   map[string]crypto.PublicKey{},
+	}
+}
+
+// DiscoverJWKSURL reads the jwks_uri from the issuer's OpenID configuration
+func DiscoverJWKSURL(ctx context.Context, issuer string) (string, error) {
+	configURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, nil)
+	if err != nil {
+		return "", err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch OpenID configuration: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch OpenID configuration: status %d", response.StatusCode)
+	}
+
+	var configuration struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&configuration); err != nil {
+		return "", fmt.Errorf("failed to parse OpenID configuration: %w", err)
+	}
+	if len(configuration.JWKSURI) == 0 {
+		return "", errors.New("OpenID configuration has no jwks_uri")
+	}
+	return configuration.JWKSURI, nil
+}
+
+// Refresh reloads the key set, the previous keys stay in use if loading fails
+func (jwks *JWKS) Refresh(ctx context.Context) error {
+	raw, err := jwks.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	var set jsonWebKeySet
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, key := range set.Keys {
+		if len(key.Use) > 0 && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			jwks.logger.Warn("Skipping unusable JWKS key", zap.String("kid", key.KeyID), zap.Error(err))
+			continue
+		}
+		keys[key.KeyID] = publicKey
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS has no usable signing keys")
+	}
+
+	jwks.mu.Lock()
+	jwks.keys = keys
+	jwks.lastRefresh = time.Now()
+	jwks.mu.Unlock()
+	jwks.logger.Info("Loaded JWKS", zap.Int("keys", len(keys)))
+	return nil
+}
+
+func (jwks *JWKS) load(ctx context.Context) ([]byte, error) {
+	if len(jwks.file) > 0 {
+		raw, err := os.ReadFile(jwks.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+		}
+		return raw, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, jwks.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := jwks.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", response.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// Key returns the key for the key id. An unknown key id triggers a refresh, issuers rotate keys ahead of
+// our refresh interval.
+func (jwks *JWKS) Key(ctx context.Context, keyId string) (crypto.PublicKey, error) {
+	jwks.mu.RLock()
+	key, ok := jwks.keys[keyId]
+	lastRefresh := jwks.lastRefresh
+	jwks.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if time.Since(lastRefresh) < unknownKeyRefreshBackoff {
+		return nil, ErrUnknownKey
+	}
+	if err := jwks.Refresh(ctx); err != nil {
+		jwks.logger.Error("Failed to refresh JWKS for unknown key", zap.String("kid", keyId), zap.Error(err))
+		return nil, ErrUnknownKey
+	}
+
+	jwks.mu.RLock()
+	key, ok = jwks.keys[keyId]
+	jwks.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// Run refreshes the key set on every interval until the context is cancelled
+func (jwks *JWKS) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := jwks.Refresh(ctx); err != nil {
+				jwks.logger.Error("Failed to refresh JWKS, keeping the previous keys", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (key jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch key.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", key.Curve)
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", key.KeyType)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrUnknownKey       = errors.New("token signed with an unknown key")
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Claims are the registered and OIDC claims the service relies on
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	NotBefore     int64    `json:"nbf"`
+	IssuedAt      int64    `json:"iat"`
+	Email         string   `json:"email"`
+	EmailVerified *bool    `json:"email_verified"`
+	HostedDomain  string   `json:"hd"` //Google Workspace domain of the account
+}
+
+// audience accepts both the single string and the array form of the aud claim
+type audience []string
+
+func (a *audience) UnmarshalJSON(raw []byte) error {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(raw, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, entry := range a {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parsedToken is a compact JWS split into its parts, not yet verified
+type parsedToken struct {
+	header       jwtHeader
+	claims       Claims
+	signingInput string
+	signature    []byte
+}
+
+func parseToken(token string) (parsedToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return parsedToken{}, ErrMalformedToken
+	}
+
+	var parsed parsedToken
+	if err := decodeSegment(parts[0], &parsed.header); err != nil {
+		return parsedToken{}, ErrMalformedToken
+	}
+	if err := decodeSegment(parts[1], &parsed.claims); err != nil {
+		return parsedToken{}, ErrMalformedToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return parsedToken{}, ErrMalformedToken
+	}
+	parsed.signature = signature
+	parsed.signingInput = parts[0] + "." + parts[1]
+	return parsed, nil
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, target)
+}
+
+// verifySignature checks the signature with the key. Only asymmetric algorithms are accepted,
+// so a token cannot pick "none" or an HMAC keyed with the public key.
+func verifySignature(token parsedToken, key crypto.PublicKey) error {
+	var hash crypto.Hash
+	switch token.header.Algorithm {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", token.header.Algorithm)
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(token.signingInput))
+	digest := hasher.Sum(nil)
+
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(token.header.Algorithm, "RS") {
+			return ErrInvalidSignature
+		}
+		if err := rsa.VerifyPKCS1v15(publicKey, hash, digest, token.signature); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(token.header.Algorithm, "ES") {
+			return ErrInvalidSignature
+		}
+		//JWS carries the raw r || s concatenation rather than ASN.1
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(token.signature) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(token.signature[:size])
+		s := new(big.Int).SetBytes(token.signature[size:])
+		if !ecdsa.Verify(publicKey, digest, r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrInvalidSignature
+	}
+}
+
+func unixTime(seconds int64) time.Time {
+	return time.Unix(seconds, 0)
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultJWKSRefresh = time.Hour // used when auth.jwks_refresh is not configured
+	clockSkewLeeway    = time.Minute
+)
+
+var (
+	ErrTokenExpired    = errors.New("token expired")
+	ErrInvalidIssuer   = errors.New("token issued by an untrusted issuer")
+	ErrInvalidAudience = errors.New("token issued for another audience")
+	ErrMissingEmail    = errors.New("token carries no verified email")
+)
+
+// TokenValidator validates OIDC ID tokens locally against the issuer's cached signing keys. The email is the
+// identity key, so a token must claim it verified. Issuers listed in unverifiedEmailIssuers only issue verified
+// emails and are trusted to leave the email_verified claim out, an explicit false is rejected for every issuer.
+type TokenValidator struct {
+	issuers                []string
+	unverifiedEmailIssuers []string
+	audience               string
+	keys                   *JWKS
+	logger                 *zap.Logger
+}
+
+func NewTokenValidator(logger *zap.Logger, issuers []string, unverifiedEmailIssuers []string, audience string, keys *JWKS) *TokenValidator {
+	return &TokenValidator{
+		issuers:                issuers,
+		unverifiedEmailIssuers: unverifiedEmailIssuers,
+		audience:               audience,
+		keys:                   keys,
+		logger:                 logger,
+	}
+}
+
+// NewTokenValidatorFromConfig builds a validator from the auth config keys and loads the signing keys.
+// auth.jwks_file takes precedence over auth.jwks_url, which is discovered from the first issuer when not set.
+// The returned JWKS has to be refreshed by running it, see JWKS.Run.
+func NewTokenValidatorFromConfig(ctx context.Context, logger *zap.Logger) (*TokenValidator, *JWKS, error) {
+	issuers := viper.GetStringSlice("auth.issuers")
+	if len(issuers) == 0 {
+		return nil, nil, errors.New("auth.issuers is required")
+	}
+	audience := viper.GetString("auth.audience")
+	if len(audience) == 0 {
+		return nil, nil, errors.New("auth.audience is required")
+	}
+
+	var keys *JWKS
+	if file := viper.GetString("auth.jwks_file"); len(file) > 0 {
+		keys = NewFileJWKS(logger, file)
+	} else {
+		url := viper.GetString("auth.jwks_url")
+		if len(url) == 0 {
+			discovered, err := DiscoverJWKSURL(ctx, issuers[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			url = discovered
+		}
+		keys = NewRemoteJWKS(logger, url)
+	}
+
+	if err := keys.Refresh(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	unverifiedEmailIssuers := viper.GetStringSlice("auth.unverified_email_issuers")
+	return NewTokenValidator(logger, issuers, unverifiedEmailIssuers, audience, keys), keys, nil
+}
+
+// Validate verifies the token's signature, issuer, audience and lifetime and returns its claims
+func (validator *TokenValidator) Validate(ctx context.Context, token string) (Claims, error) {
+	parsed, err := parseToken(token)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	key, err := validator.keys.Key(ctx, parsed.header.KeyID)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := verifySignature(parsed, key); err != nil {
+		return Claims{}, err
+	}
+
+	claims := parsed.claims
+	if !validator.trustsIssuer(claims.Issuer) {
+		return Claims{}, ErrInvalidIssuer
+	}
+	if !claims.Audience.contains(validator.audience) {
+		return Claims{}, ErrInvalidAudience
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.After(unixTime(claims.ExpiresAt).Add(clockSkewLeeway)) {
+		return Claims{}, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkewLeeway).Before(unixTime(claims.NotBefore)) {
+		return Claims{}, ErrTokenExpired
+	}
+	if claims.IssuedAt != 0 && now.Add(clockSkewLeeway).Before(unixTime(claims.IssuedAt)) {
+		return Claims{}, ErrTokenExpired
+	}
+
+	if len(claims.Email) == 0 || !validator.emailVerified(claims) {
+		return Claims{}, ErrMissingEmail
+	}
+	return claims, nil
+}
+
+func (validator *TokenValidator) emailVerified(claims Claims) bool {
+	if claims.EmailVerified == nil {
+		return contains(validator.unverifiedEmailIssuers, claims.Issuer)
+	}
+	return *claims.EmailVerified
+}
+
+func (validator *TokenValidator) trustsIssuer(issuer string) bool {
+	return contains(validator.issuers, issuer)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
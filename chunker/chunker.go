@@ -0,0 +1,64 @@
+package chunker
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultChunkSize = 1024 * 1024 // 1MB in bytes
+	minChunkSize     = 64          // anything smaller makes the per chunk bookkeeping outweigh the content
+)
+
+// Chunker splits a stream into chunks. Next returns io.EOF once the stream is exhausted.
+type Chunker interface {
+	Next() ([]byte, error)
+}
+
+// New builds the chunker selected by the chunking.algorithm config key over the given reader
+func New(r io.Reader) (Chunker, error) {
+	size := viper.GetInt("chunking.size")
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if size < minChunkSize {
+		return nil, fmt.Errorf("chunk size %d is below the minimum of %d bytes", size, minChunkSize)
+	}
+
+	algorithm := viper.GetString("chunking.algorithm")
+	switch algorithm {
+	case "fixed":
+		return NewFixedSizeChunker(r, size), nil
+	case "fastcdc", "":
+		return NewFastCDCChunker(r, size/4, size, size*4), nil
+	default:
+		return nil, fmt.Errorf("unknown chunking algorithm %q", algorithm)
+	}
+}
+
+// FixedSizeChunker cuts the stream every size bytes, the last chunk may be shorter
+type FixedSizeChunker struct {
+	reader io.Reader
+	size   int
+}
+
+func NewFixedSizeChunker(r io.Reader, size int) *FixedSizeChunker {
+	return &FixedSizeChunker{
+		reader: r,
+		size:   size,
+	}
+}
+
+func (c *FixedSizeChunker) Next() ([]byte, error) {
+	chunk := make([]byte, c.size)
+	n, err := io.ReadFull(c.reader, chunk)
+	if err == io.ErrUnexpectedEOF {
+		return chunk[:n], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
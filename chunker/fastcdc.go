@@ -0,0 +1,118 @@
+package chunker
+
+import (
+	"io"
+	"math/bits"
+)
+
+// gear maps every byte to a pseudo random value for the rolling hash. It is generated from a fixed seed
+// so chunk boundaries, and with them dedup, stay stable across restarts and releases.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x5661756c74434443) //"VaultCDC"
+	for i := range table {
+		//splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// FastCDCChunker cuts the stream at content defined boundaries using a gear rolling hash with normalized
+// chunking, so an insertion early in a file only changes the chunks around it.
+// See "FastCDC: a Fast and Efficient Content-Defined Chunking Approach for Data Deduplication" (Xia et al.)
+type FastCDCChunker struct {
+	reader  io.Reader
+	buf     []byte
+	eof     bool
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64 //stricter mask used before the average size is reached
+	maskL   uint64 //looser mask used after
+}
+
+func NewFastCDCChunker(r io.Reader, minSize int, avgSize int, maxSize int) *FastCDCChunker {
+	avgBits := bits.Len(uint(avgSize)) - 1
+	return &FastCDCChunker{
+		reader:  r,
+		buf:     make([]byte, 0, maxSize),
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+		maskS:   highBitsMask(avgBits + 1),
+		maskL:   highBitsMask(avgBits - 1),
+	}
+}
+
+func (c *FastCDCChunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+
+	cut := c.cutPoint(c.buf)
+	chunk := make([]byte, cut)
+	copy(chunk, c.buf[:cut])
+	c.buf = c.buf[:copy(c.buf, c.buf[cut:])]
+	return chunk, nil
+}
+
+// fill tops the buffer up to the max chunk size, or whatever is left of the stream
+func (c *FastCDCChunker) fill() error {
+	for len(c.buf) < c.maxSize && !c.eof {
+		n, err := c.reader.Read(c.buf[len(c.buf):c.maxSize])
+		c.buf = c.buf[:len(c.buf)+n]
+		if err == io.EOF {
+			c.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *FastCDCChunker) cutPoint(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	if n > c.maxSize {
+		n = c.maxSize
+	}
+	normal := c.avgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fingerprint uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		fingerprint = (fingerprint << 1) + gear[data[i]]
+		if fingerprint&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fingerprint = (fingerprint << 1) + gear[data[i]]
+		if fingerprint&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// the gear hash shifts left on every byte, so its high bits carry the longest window of content
+func highBitsMask(count int) uint64 {
+	if count <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - count)
+}
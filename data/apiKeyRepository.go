@@ -0,0 +1,140 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+)
+
+// APIKey is a long lived credential. Only the SHA-256 of the key is stored, the key itself is shown once on creation.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	OwnerEmail string             `bson:"owner_email"`
+	Name       string             `bson:"name"`
+	Prefix     string             `bson:"prefix"` //first characters of the key, to tell keys apart
+	KeyHash    string             `bson:"key_hash"`
+	Scopes     []string           `bson:"scopes,omitempty"` //empty means unrestricted
+	CreatedAt  time.Time          `bson:"created_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty"`
+}
+
+var ErrAPIKeyNotFound = apperror.NotFound("api_key_not_found", "api key not found").Wrap(ErrNotFound)
+
+type MongoAPIKeyRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	retry      retryPolicy
+}
+
+func NewMongoAPIKeyRepository(db *MongoDB, logger *zap.Logger) *MongoAPIKeyRepository {
+	return &MongoAPIKeyRepository{
+		collection: db.GetDatabase().Collection("api_key"),
+		logger:     logger,
+		retry:      db.retry,
+	}
+}
+
+func (repo *MongoAPIKeyRepository) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "owner_email", Value: 1}, {Key: "created_at", Value: 1}}},
+	}
+	_, err := repo.collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		repo.logger.Error("Failed to create api key indexes", zap.Error(err))
+		return wrapError(err)
+	}
+	return nil
+}
+
+func (repo *MongoAPIKeyRepository) Add(ctx context.Context, key APIKey) (APIKey, error) {
+	if key.ID.IsZero() {
+		key.ID = primitive.NewObjectID()
+	}
+	err := repo.retry.do(ctx, repo.logger, "add api key", func(ctx context.Context) error {
+		return insertOnce(ctx, repo.collection, key.ID, key)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong creating the api key", zap.Error(err))
+		return APIKey{}, err
+	}
+	repo.logger.Info("Created a new api key successfully", zap.Any("objectId", key.ID))
+	return key, nil
+}
+
+// GetByHash fails with ErrAPIKeyNotFound if no key has the hash, revoked keys are returned
+func (repo *MongoAPIKeyRepository) GetByHash(ctx context.Context, keyHash string) (APIKey, error) {
+	var key APIKey
+	err := repo.retry.do(ctx, repo.logger, "get api key", func(ctx context.Context) error {
+		return repo.collection.FindOne(ctx, bson.M{"key_hash": keyHash}).Decode(&key)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		repo.logger.Error("Something went wrong getting api key", zap.Error(err))
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (repo *MongoAPIKeyRepository) ListByOwner(ctx context.Context, ownerEmail string) ([]APIKey, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	keys := []APIKey{}
+	err := repo.retry.do(ctx, repo.logger, "list api keys", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, bson.M{"owner_email": ownerEmail}, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &keys)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong listing api keys", zap.String("owner_email", ownerEmail), zap.Error(err))
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke revokes the owner's key, revoking an already revoked key is a no-op
+func (repo *MongoAPIKeyRepository) Revoke(ctx context.Context, keyId primitive.ObjectID, ownerEmail string) error {
+	filter := bson.M{"_id": keyId, "owner_email": ownerEmail}
+	update := bson.M{"$min": bson.M{"revoked_at": time.Now()}}
+	var matched int64
+	err := repo.retry.do(ctx, repo.logger, "revoke api key", func(ctx context.Context) error {
+		result, err := repo.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		matched = result.MatchedCount
+		return nil
+	})
+	if err != nil {
+		repo.logger.Error("Failed to revoke api key", zap.Any("key_id", keyId), zap.Error(err))
+		return err
+	}
+	if matched == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (repo *MongoAPIKeyRepository) TouchLastUsed(ctx context.Context, keyId primitive.ObjectID) error {
+	err := repo.retry.do(ctx, repo.logger, "record api key use", func(ctx context.Context) error {
+		_, err := repo.collection.UpdateOne(ctx, bson.M{"_id": keyId}, bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+		return err
+	})
+	if err != nil {
+		repo.logger.Error("Failed to record api key use", zap.Any("key_id", keyId), zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -2,42 +2,407 @@ package data
 
 import (
 	"context"
+	"time"
 
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 )
 
+// Chunk is a piece of content addressed by its hash, stored once however many file versions use it.
+// RefCount counts the uses, a version holding the same chunk twice holds two references.
+// Chunks recorded before reference counting have no ref_count, each of them belongs to a single file.
+// Status and VerifiedAt are the result of the last scrub of the content, empty until the chunk is first scrubbed.
+// SHA256 is the hex digest of the content, scrubs verify the content against it. Chunks recorded before it was
+// computed on upload get it on their first scrub.
 type Chunk struct {
-	ID   primitive.ObjectID `bson:"_id,omitempty"`
-	Hash string             `bson:"hash"`
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Hash       string             `bson:"hash"`
+	Size       int64              `bson:"size"`
+	SHA256     string             `bson:"sha256,omitempty"`
+	RefCount   int64              `bson:"ref_count"`
+	Status     string             `bson:"status,omitempty"`
+	VerifiedAt *time.Time         `bson:"verified_at,omitempty"`
 }
-type ChunkRepository struct {
+
+// statuses a scrub records on a chunk
+const (
+	ChunkStatusOK      = "ok"
+	ChunkStatusMissing = "missing" //the storage backend no longer has the content
+	ChunkStatusCorrupt = "corrupt" //the content no longer matches the hash
+)
+
+// ChunkStats compares the bytes file versions reference with the bytes actually stored,
+// the difference is what deduplication saved
+type ChunkStats struct {
+	Chunks          int64 `bson:"chunks"`
+	StoredBytes     int64 `bson:"stored_bytes"`
+	ReferencedBytes int64 `bson:"referenced_bytes"`
+}
+
+// ReleasedContent is content whose chunk lost its last reference, or that an upload stored and did not commit.
+// The garbage collector removes it once it stayed unreferenced for the grace period.
+type ReleasedContent struct {
+	Hash       string    `bson:"_id"`
+	ReleasedAt time.Time `bson:"released_at"` //when the content was first released
+}
+
+var ErrChunkNotFound = apperror.NotFound("chunk_not_found", "chunk not found").Wrap(ErrNotFound)
+
+// matches the chunks that are reference counted, chunks recorded before that are left alone
+var refCounted = bson.M{"$exists": true}
+
+type MongoChunkRepository struct {
 	collection *mongo.Collection
+	released   *mongo.Collection
 	logger     *zap.Logger
+	retry      retryPolicy
 }
 
-func NewChunkRepository(db *MongoDB, logger *zap.Logger) *ChunkRepository {
-	return &ChunkRepository{
+func NewMongoChunkRepository(db *MongoDB, logger *zap.Logger) *MongoChunkRepository {
+	return &MongoChunkRepository{
 		collection: db.GetDatabase().Collection("chunk"),
+		released:   db.GetDatabase().Collection("released_content"),
 		logger:     logger,
+		retry:      db.retry,
+	}
+}
+
+// EnsureIndexes makes hashes unique among reference counted chunks. Chunks recorded before reference counting
+// may repeat a hash, they are left out of the index.
+func (repo *MongoChunkRepository) EnsureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"ref_count": refCounted}),
+	}
+	_, err := repo.collection.Indexes().CreateOne(ctx, index)
+	if err != nil {
+		repo.logger.Error("Failed to create chunk indexes", zap.Error(err))
+		return wrapError(err)
+	}
+	return nil
+}
+
+// Acquire takes a reference on the content with the hash, recording the chunk on first use, and records the SHA-256
+// digest of the content if given. Returns the chunk with its reference count after the increment.
+// Reference counts are not retried here, an increment is not idempotent. Callers run them in a UnitOfWork,
+// which retries the transaction as a whole.
+func (repo *MongoChunkRepository) Acquire(ctx context.Context, hash string, size int64, digest string) (Chunk, error) {
+	filter := bson.M{"hash": hash, "ref_count": refCounted}
+	update := bson.M{
+		"$setOnInsert": bson.M{"size": size},
+		"$inc":         bson.M{"ref_count": 1},
+	}
+	if len(digest) > 0 {
+		//the same address is the same content, so the digest is the same for every upload of it
+		update["$set"] = bson.M{"sha256": digest}
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var chunk Chunk
+	err := repo.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&chunk)
+	if mongo.IsDuplicateKeyError(err) {
+		//a concurrent first use recorded the chunk between our match and insert, it matches now
+		err = repo.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&chunk)
+	}
+	if err != nil {
+		repo.logger.Error("Something went wrong acquiring the chunk", zap.String("hash", hash), zap.Error(err))
+		return Chunk{}, wrapError(err)
 	}
+	return chunk, nil
 }
 
-// returns the object id of the inserted chunk hash
-func (repo *ChunkRepository) Add(chunk Chunk) (Chunk, error) {
-	insertResult, err := repo.collection.InsertOne(context.Background(), chunk)
+// AddReferences takes one more reference on every chunk for each time its id is listed. Not retried, same as Acquire.
+func (repo *MongoChunkRepository) AddReferences(ctx context.Context, chunkIds []primitive.ObjectID) error {
+	for id, count := range countIds(chunkIds) {
+		filter := bson.M{"_id": id, "ref_count": refCounted}
+		_, err := repo.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"ref_count": count}})
+		if err != nil {
+			repo.logger.Error("Failed to add chunk references", zap.Any("chunk_id", id), zap.Error(err))
+			return wrapError(err)
+		}
+	}
+	return nil
+}
+
+// Release drops a reference on every chunk for each time its id is listed, and deletes the chunks left without
+// references along with the ones recorded before reference counting. Returns the deleted chunks, whose content
+// may now be unreferenced. Not retried, same as Acquire.
+func (repo *MongoChunkRepository) Release(ctx context.Context, chunkIds []primitive.ObjectID) ([]Chunk, error) {
+	for id, count := range countIds(chunkIds) {
+		filter := bson.M{"_id": id, "ref_count": refCounted}
+		_, err := repo.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"ref_count": -count}})
+		if err != nil {
+			repo.logger.Error("Failed to release chunk references", zap.Any("chunk_id", id), zap.Error(err))
+			return nil, wrapError(err)
+		}
+	}
+
+	released := bson.M{"_id": bson.M{"$in": chunkIds}, "$or": unreferenced}
+	cursor, err := repo.collection.Find(ctx, released)
+	if err != nil {
+		repo.logger.Error("Failed to find unreferenced chunks", zap.Any("chunk_ids", chunkIds), zap.Error(err))
+		return nil, wrapError(err)
+	}
+	chunks := []Chunk{}
+	if err := cursor.All(ctx, &chunks); err != nil {
+		repo.logger.Error("Failed to decode unreferenced chunks", zap.Any("chunk_ids", chunkIds), zap.Error(err))
+		return nil, wrapError(err)
+	}
+	if len(chunks) == 0 {
+		return chunks, nil
+	}
+
+	if _, err := repo.collection.DeleteMany(ctx, released); err != nil {
+		repo.logger.Error("Failed to delete unreferenced chunks", zap.Any("chunk_ids", chunkIds), zap.Error(err))
+		return nil, wrapError(err)
+	}
+	return chunks, nil
+}
+
+// AddReleased records the content at the hashes as released, content released before keeps its first release time
+func (repo *MongoChunkRepository) AddReleased(ctx context.Context, hashes []string, releasedAt time.Time) error {
+	for _, hash := range hashes {
+		update := bson.M{"$setOnInsert": bson.M{"released_at": releasedAt}}
+		err := repo.retry.do(ctx, repo.logger, "add released content", func(ctx context.Context) error {
+			_, err := repo.released.UpdateOne(ctx, bson.M{"_id": hash}, update, options.Update().SetUpsert(true))
+			if mongo.IsDuplicateKeyError(err) {
+				//a concurrent release recorded the content first
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			repo.logger.Error("Failed to record released content", zap.String("hash", hash), zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// ListReleased returns a page of the released content, ordered by hash
+func (repo *MongoChunkRepository) ListReleased(ctx context.Context, afterHash string, limit int64) ([]ReleasedContent, error) {
+	filter := bson.M{}
+	if len(afterHash) > 0 {
+		filter["_id"] = bson.M{"$gt": afterHash}
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+
+	released := []ReleasedContent{}
+	err := repo.retry.do(ctx, repo.logger, "list released content", func(ctx context.Context) error {
+		cursor, err := repo.released.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &released)
+	})
 	if err != nil {
-		repo.logger.Fatal("Something went wrong creating the user", zap.Error(err))
-		return Chunk{}, nil
+		repo.logger.Error("Something went wrong listing released content", zap.Error(err))
+		return nil, err
 	}
-	repo.logger.Info("Created a new chunk successfully", zap.Any("objectId", insertResult.InsertedID))
-	insertedChunk := Chunk{}
-	err = repo.collection.FindOne(context.Background(), bson.M{"_id": insertResult.InsertedID}).Decode(&insertedChunk)
+	return released, nil
+}
+
+// RemoveReleased forgets the released content, once it is removed from storage or referenced again
+func (repo *MongoChunkRepository) RemoveReleased(ctx context.Context, hash string) error {
+	err := repo.retry.do(ctx, repo.logger, "remove released content", func(ctx context.Context) error {
+		_, err := repo.released.DeleteOne(ctx, bson.M{"_id": hash})
+		return err
+	})
 	if err != nil {
-		repo.logger.Error("Something went wrong getting user by object id", zap.Any("_id", insertResult.InsertedID))
-		return Chunk{}, nil
+		repo.logger.Error("Failed to remove released content", zap.String("hash", hash), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// unreferenced matches chunks without references and chunks recorded before reference counting
+var unreferenced = bson.A{
+	bson.M{"ref_count": bson.M{"$lte": 0}},
+	bson.M{"ref_count": bson.M{"$exists": false}},
+}
+
+// ListUnreferenced returns a page of the chunks recorded before the cutoff without references, ordered by id.
+// Chunks recorded before reference counting are listed too, whether a file still holds them is up to the caller.
+func (repo *MongoChunkRepository) ListUnreferenced(ctx context.Context, cutoff time.Time, afterId primitive.ObjectID, limit int64) ([]Chunk, error) {
+	idRange := bson.M{"$lt": primitive.NewObjectIDFromTimestamp(cutoff)}
+	if !afterId.IsZero() {
+		idRange["$gt"] = afterId
+	}
+	filter := bson.M{"_id": idRange, "$or": unreferenced}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+
+	chunks := []Chunk{}
+	err := repo.retry.do(ctx, repo.logger, "list unreferenced chunks", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &chunks)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong listing unreferenced chunks", zap.Error(err))
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// DeleteUnreferenced deletes the chunk unless it was referenced again since it was listed, reports whether it was
+// deleted. A retry of a delete that went through reports the chunk as not deleted, the garbage collector released
+// its content before.
+func (repo *MongoChunkRepository) DeleteUnreferenced(ctx context.Context, chunkId primitive.ObjectID) (bool, error) {
+	var deleted bool
+	err := repo.retry.do(ctx, repo.logger, "delete unreferenced chunk", func(ctx context.Context) error {
+		result, err := repo.collection.DeleteOne(ctx, bson.M{"_id": chunkId, "$or": unreferenced})
+		if err != nil {
+			return err
+		}
+		deleted = deleted || result.DeletedCount > 0
+		return nil
+	})
+	if err != nil {
+		repo.logger.Error("Failed to delete unreferenced chunk", zap.Any("chunk_id", chunkId), zap.Error(err))
+		return false, err
+	}
+	return deleted, nil
+}
+
+// ListUnverified returns a page of the chunks not scrubbed since verifiedBefore, never scrubbed ones included,
+// ordered by id
+func (repo *MongoChunkRepository) ListUnverified(ctx context.Context, verifiedBefore time.Time, afterId primitive.ObjectID, limit int64) ([]Chunk, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"verified_at": bson.M{"$exists": false}},
+		bson.M{"verified_at": bson.M{"$lt": verifiedBefore}},
+	}}
+	if !afterId.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterId}
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+
+	chunks := []Chunk{}
+	err := repo.retry.do(ctx, repo.logger, "list unverified chunks", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &chunks)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong listing unverified chunks", zap.Error(err))
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// SetVerification records the result of scrubbing the chunk, and the digest if given for a chunk that had none
+func (repo *MongoChunkRepository) SetVerification(ctx context.Context, chunkId primitive.ObjectID, status string, verifiedAt time.Time, digest string) error {
+	fields := bson.M{"status": status, "verified_at": verifiedAt}
+	if len(digest) > 0 {
+		fields["sha256"] = digest
+	}
+	update := bson.M{"$set": fields}
+	err := repo.retry.do(ctx, repo.logger, "set chunk verification", func(ctx context.Context) error {
+		_, err := repo.collection.UpdateOne(ctx, bson.M{"_id": chunkId}, update)
+		return err
+	})
+	if err != nil {
+		repo.logger.Error("Failed to record chunk verification", zap.Any("chunk_id", chunkId), zap.String("status", status), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// returns the chunks for the given ids, in the same order as the ids
+func (repo *MongoChunkRepository) GetMany(ctx context.Context, chunkIds []primitive.ObjectID) ([]Chunk, error) {
+	var found []Chunk
+	err := repo.retry.do(ctx, repo.logger, "get chunks", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, bson.M{"_id": bson.M{"$in": chunkIds}})
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &found)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong getting chunks", zap.Any("chunk_ids", chunkIds), zap.Error(err))
+		return nil, err
+	}
+
+	lookup := make(map[primitive.ObjectID]Chunk, len(found))
+	for _, chunk := range found {
+		lookup[chunk.ID] = chunk
+	}
+
+	chunks := make([]Chunk, 0, len(chunkIds))
+	for _, id := range chunkIds {
+		chunk, ok := lookup[id]
+		if !ok {
+			repo.logger.Error("Chunk referenced by file is missing", zap.Any("chunk_id", id))
+			return nil, ErrChunkNotFound
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// reports whether any chunk still holds a reference on the content hash
+func (repo *MongoChunkRepository) IsReferenced(ctx context.Context, hash string) (bool, error) {
+	filter := bson.M{
+		"hash": hash,
+		"$or": bson.A{
+			bson.M{"ref_count": bson.M{"$gt": 0}},
+			bson.M{"ref_count": bson.M{"$exists": false}},
+		},
+	}
+	var count int64
+	err := repo.retry.do(ctx, repo.logger, "count chunks by hash", func(ctx context.Context) error {
+		var err error
+		count, err = repo.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		return err
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong counting chunks by hash", zap.String("hash", hash), zap.Error(err))
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Stats totals every chunk, a chunk recorded before reference counting is referenced once
+func (repo *MongoChunkRepository) Stats(ctx context.Context) (ChunkStats, error) {
+	references := bson.M{"$ifNull": bson.A{"$ref_count", 1}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":              nil,
+			"chunks":           bson.M{"$sum": 1},
+			"stored_bytes":     bson.M{"$sum": "$size"},
+			"referenced_bytes": bson.M{"$sum": bson.M{"$multiply": bson.A{"$size", references}}},
+		}}},
+	}
+	var results []ChunkStats
+	err := repo.retry.do(ctx, repo.logger, "aggregate chunk stats", func(ctx context.Context) error {
+		cursor, err := repo.collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &results)
+	})
+	if err != nil {
+		repo.logger.Error("Failed to aggregate chunk stats", zap.Error(err))
+		return ChunkStats{}, err
+	}
+	if len(results) == 0 {
+		return ChunkStats{}, nil
+	}
+	return results[0], nil
+}
+
+// countIds counts how many times each id is listed
+func countIds(ids []primitive.ObjectID) map[primitive.ObjectID]int64 {
+	counts := make(map[primitive.ObjectID]int64, len(ids))
+	for _, id := range ids {
+		counts[id]++
 	}
-	return insertedChunk, nil
+	return counts
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 var (
@@ -21,6 +22,7 @@ type Database interface {
 
 type MongoDB struct {
 	connection *mongo.Client
+	retry      retryPolicy //shared by the repositories
 }
 
 func GetMongoDBInstance() *MongoDB {
@@ -47,9 +49,23 @@ func createMongoDBConnection(addr string) *MongoDB {
 
 	return &MongoDB{
 		connection: client,
+		retry:      newRetryPolicyFromConfig(),
 	}
 }
 
 func (db *MongoDB) GetDatabase() *mongo.Database {
 	return db.connection.Database(viper.GetString("database.name"))
 }
+
+func (db *MongoDB) GetConnection() *mongo.Client {
+	return db.connection
+}
+
+// Ping checks the primary is reachable
+func (db *MongoDB) Ping(ctx context.Context) error {
+	return db.connection.Ping(ctx, readpref.Primary())
+}
+
+func (db *MongoDB) Disconnect(ctx context.Context) error {
+	return db.connection.Disconnect(ctx)
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Repositories return database failures wrapped in one of these, match them with errors.Is. The more specific
+// not found errors, e.g. ErrUserNotFound, match ErrNotFound too.
+var (
+	ErrNotFound  = apperror.NotFound("not_found", "document not found")
+	ErrDuplicate = apperror.New(apperror.KindConflict, "duplicate", "document already exists")
+	ErrTransient = apperror.New(apperror.KindStorageUnavailable, "database_unavailable", "database is temporarily unavailable, retry later")
+)
+
+// wrapError classifies a driver error. Errors already carrying a kind, e.g. ErrVersionConflict, are returned as is,
+// errors that are none of not found, duplicate or transient are returned unchanged.
+func wrapError(err error) error {
+	var appErr *apperror.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &appErr):
+		return err
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound.Wrap(err)
+	case mongo.IsDuplicateKeyError(err):
+		return ErrDuplicate.Wrap(err)
+	case isTransient(err):
+		return ErrTransient.Wrap(err)
+	}
+	return err
+}
+
+// isTransient reports errors worth retrying: lost connections, server selection and socket timeouts, and errors
+// the server itself labels as retryable
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) {
+		return labeled.HasErrorLabel("RetryableWriteError") || labeled.HasErrorLabel("TransientTransactionError")
+	}
+	return false
+}
+
+// insertOnce inserts the document with a client side id. A retried insert can hit the duplicate id of its own
+// earlier attempt, which did succeed, so a duplicate of that id counts as inserted.
+func insertOnce(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, document interface{}) error {
+	_, err := collection.InsertOne(ctx, document)
+	if err != nil && mongo.IsDuplicateKeyError(err) {
+		count, countErr := collection.CountDocuments(ctx, bson.M{"_id": id})
+		if countErr == nil && count > 0 {
+			return nil
+		}
+	}
+	return err
+}
@@ -2,44 +2,458 @@ package data
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
 )
 
+// File holds the current version's content fields at the top level, Versions keeps the full history including it
 type File struct {
-	ID       primitive.ObjectID   `bson:"_id,omitempty"`
-	Name     string               `bson:"name"`
-	Type     string               `bson:"type"`
-	ChunkIDs []primitive.ObjectID `bson:"chunk_ids"`
+	ID            primitive.ObjectID   `bson:"_id,omitempty"`
+	OwnerID       primitive.ObjectID   `bson:"owner_id"`
+	Name          string               `bson:"name"`
+	Type          string               `bson:"type"`
+	Size          int64                `bson:"size"`
+	Hash          string               `bson:"hash"`          //SHA-256 hex digest of the content
+	MD5           string               `bson:"md5,omitempty"` //MD5 hex digest of the content, recorded with upload.md5 or when the client sent one
+	ChunkIDs      []primitive.ObjectID `bson:"chunk_ids"`
+	Version       int                  `bson:"version"`
+	Versions      []FileVersion        `bson:"versions"`
+	Collaborators []Collaborator       `bson:"collaborators,omitempty"` //users other than the owner with a role on the file
+	CreatedAt     time.Time            `bson:"created_at"`
+	UpdatedAt     time.Time            `bson:"updated_at"`
+	DeletedAt     *time.Time           `bson:"deleted_at,omitempty"` //set while the file sits in its owner's trash
+}
+
+// Collaborator grants a role on a file to a user, identified by id once known and by email until then.
+// A collaborator without a user id is a pending share for someone who has not signed in yet.
+type Collaborator struct {
+	UserID   primitive.ObjectID `bson:"user_id,omitempty"`
+	Email    string             `bson:"email"`
+	Role     string             `bson:"role"`
+	SharedBy string             `bson:"shared_by"`
+	SharedAt time.Time          `bson:"shared_at"`
 }
 
-type FileRepository struct {
+type FileVersion struct {
+	Number     int                  `bson:"number"`
+	ChunkIDs   []primitive.ObjectID `bson:"chunk_ids"`
+	Size       int64                `bson:"size"`
+	Hash       string               `bson:"hash"`
+	MD5        string               `bson:"md5,omitempty"`
+	UploadedBy string               `bson:"uploaded_by"`
+	CreatedAt  time.Time            `bson:"created_at"`
+}
+
+var (
+	ErrFileNotFound    = apperror.NotFound("file_not_found", "file not found").Wrap(ErrNotFound)
+	ErrVersionConflict = apperror.New(apperror.KindConflict, "version_conflict", "file was updated concurrently")
+)
+
+type MongoFileRepository struct {
 	collection *mongo.Collection
 	logger     *zap.Logger
+	retry      retryPolicy
 }
 
-func NewFileRepository(db *MongoDB, logger *zap.Logger) *FileRepository {
-	return &FileRepository{
+func NewMongoFileRepository(db *MongoDB, logger *zap.Logger) *MongoFileRepository {
+	return &MongoFileRepository{
 		collection: db.GetDatabase().Collection("file"),
 		logger:     logger,
+		retry:      db.retry,
+	}
+}
+
+// EnsureIndexes creates the indexes backing file listings, one per sortable field
+func (repo *MongoFileRepository) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{}
+	for _, field := range []string{"name", "size", "created_at", "updated_at"} {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "deleted_at", Value: 1}, {Key: field, Value: 1}, {Key: "_id", Value: 1}},
+		})
+	}
+	indexes = append(indexes, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "type", Value: 1}, {Key: "deleted_at", Value: 1}, {Key: "updated_at", Value: 1}},
+	})
+	//shared with me lookups
+	indexes = append(indexes,
+		mongo.IndexModel{Keys: bson.D{{Key: "collaborators.user_id", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "collaborators.email", Value: 1}}},
+	)
+
+	_, err := repo.collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		repo.logger.Error("Failed to create file indexes", zap.Error(err))
+		return wrapError(err)
+	}
+	return nil
+}
+
+// returns the inserted file with its object id
+func (repo *MongoFileRepository) Add(ctx context.Context, file File) (File, error) {
+	if file.ID.IsZero() {
+		file.ID = primitive.NewObjectID()
+	}
+	err := repo.retry.do(ctx, repo.logger, "add file", func(ctx context.Context) error {
+		return insertOnce(ctx, repo.collection, file.ID, file)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong creating the file", zap.String("name", file.Name), zap.Error(err))
+		return File{}, err
+	}
+	repo.logger.Info("Created a new file successfully", zap.Any("objectId", file.ID))
+	return file, nil
+}
+
+// Get fails with ErrFileNotFound if no file has the id, trashed files included
+func (repo *MongoFileRepository) Get(ctx context.Context, fileDocumentId primitive.ObjectID) (File, error) {
+	var file File
+	err := repo.retry.do(ctx, repo.logger, "get file", func(ctx context.Context) error {
+		return repo.collection.FindOne(ctx, bson.M{"_id": fileDocumentId}).Decode(&file)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return File{}, ErrFileNotFound
+	}
+	if err != nil {
+		repo.logger.Error("Something went wrong getting file by object id", zap.Any("file_id", fileDocumentId), zap.Error(err))
+		return File{}, err
+	}
+	return file, nil
+}
+
+// GetDeleted returns the files among the given ids that are in the trash
+func (repo *MongoFileRepository) GetDeleted(ctx context.Context, fileDocumentIds []primitive.ObjectID) ([]File, error) {
+	filter := bson.M{
+		"_id":        bson.M{"$in": fileDocumentIds},
+		"deleted_at": bson.M{"$ne": nil},
+	}
+	return repo.find(ctx, filter)
+}
+
+// GetDeletedBefore returns the files moved to the trash before the cutoff
+func (repo *MongoFileRepository) GetDeletedBefore(ctx context.Context, cutoff time.Time) ([]File, error) {
+	return repo.find(ctx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
+}
+
+// ListAll returns a page of every file, whoever owns it and the trash included, ordered by id
+func (repo *MongoFileRepository) ListAll(ctx context.Context, afterId primitive.ObjectID, limit int64) ([]File, error) {
+	filter := bson.M{}
+	if !afterId.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterId}
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+
+	files := []File{}
+	err := repo.retry.do(ctx, repo.logger, "list all files", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &files)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong listing all files", zap.Error(err))
+		return nil, err
+	}
+	return files, nil
+}
+
+func (repo *MongoFileRepository) find(ctx context.Context, filter bson.M) ([]File, error) {
+	files := []File{}
+	err := repo.retry.do(ctx, repo.logger, "find files", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &files)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong finding files", zap.Any("filter", filter), zap.Error(err))
+		return nil, err
+	}
+	return files, nil
+}
+
+// SoftDelete moves the file to the trash. Not retried, a retry of a move that went through finds no file to move.
+func (repo *MongoFileRepository) SoftDelete(ctx context.Context, fileDocumentId primitive.ObjectID) error {
+	filter := bson.M{"_id": fileDocumentId, "deleted_at": nil}
+	update := bson.M{"$set": bson.M{"deleted_at": time.Now()}}
+	return repo.updateOne(ctx, filter, update)
+}
+
+// Restore takes the file back out of the trash. Not retried, same as SoftDelete.
+func (repo *MongoFileRepository) Restore(ctx context.Context, fileDocumentId primitive.ObjectID) error {
+	filter := bson.M{"_id": fileDocumentId, "deleted_at": bson.M{"$ne": nil}}
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}}
+	return repo.updateOne(ctx, filter, update)
+}
+
+func (repo *MongoFileRepository) updateOne(ctx context.Context, filter bson.M, update bson.M) error {
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		repo.logger.Error("Failed to update file", zap.Any("filter", filter), zap.Error(err))
+		return wrapError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrFileNotFound.WithMessage("no file matched the update")
+	}
+	return nil
+}
+
+// Delete permanently removes the file document
+func (repo *MongoFileRepository) Delete(ctx context.Context, fileDocumentId primitive.ObjectID) error {
+	err := repo.retry.do(ctx, repo.logger, "delete file", func(ctx context.Context) error {
+		_, err := repo.collection.DeleteOne(ctx, bson.M{"_id": fileDocumentId})
+		return err
+	})
+	if err != nil {
+		repo.logger.Error("Failed to delete file", zap.Any("file_id", fileDocumentId), zap.Error(err))
+		return err
 	}
+	repo.logger.Info("Deleted file permanently", zap.Any("file_id", fileDocumentId))
+	return nil
 }
 
-func (repo *FileRepository) Add(file File) (File, error) {
-	insertResult, err := repo.collection.InsertOne(context.Background(), file)
+// SetVersions replaces the version history and makes its last entry the current version.
+// Fails with ErrVersionConflict if the file is no longer at expectedVersion, i.e. another update got there first.
+// Not retried, a retry of an update that went through would fail with ErrVersionConflict; callers run it in a
+// UnitOfWork, which retries the transaction as a whole.
+func (repo *MongoFileRepository) SetVersions(ctx context.Context, fileDocumentId primitive.ObjectID, expectedVersion int, versions []FileVersion) error {
+	filter := bson.M{"_id": fileDocumentId, "deleted_at": nil, "version": expectedVersion}
+	if expectedVersion == 0 {
+		//files uploaded before versioning have no version field
+		filter["version"] = bson.M{"$exists": false}
+	}
+
+	current := versions[len(versions)-1]
+	update := bson.M{
+		"$set": bson.M{
+			"version":    current.Number,
+			"chunk_ids":  current.ChunkIDs,
+			"size":       current.Size,
+			"hash":       current.Hash,
+			"md5":        current.MD5,
+			"versions":   versions,
+			"updated_at": current.CreatedAt,
+		},
+	}
+
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		repo.logger.Fatal("Something went wrong creating the file", zap.Error(err))
-		return File{}, nil
+		repo.logger.Error("Failed to update file versions", zap.Any("file_id", fileDocumentId), zap.Error(err))
+		return wrapError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrVersionConflict
 	}
-	repo.logger.Info("Created a new file successfully", zap.Any("objectId", insertResult.InsertedID))
-	insertedFile := File{}
-	err = repo.collection.FindOne(context.Background(), bson.M{"_id": insertResult.InsertedID}).Decode(&insertedFile)
+	return nil
+}
+
+// FileListQuery selects a page of an owner's files, excluding the trash
+type FileListQuery struct {
+	OwnerID    primitive.ObjectID
+	Type       string //optional
+	SortField  string //one of name, size, created_at, updated_at
+	Descending bool
+	Limit      int64
+	// keyset cursor, the page starts after the file with this sort value and id
+	AfterValue interface{}
+	AfterID    primitive.ObjectID
+}
+
+func (repo *MongoFileRepository) List(ctx context.Context, query FileListQuery) ([]File, error) {
+	filter := bson.M{"owner_id": query.OwnerID, "deleted_at": nil}
+	if len(query.Type) > 0 {
+		filter["type"] = query.Type
+	}
+
+	direction, comparison := 1, "$gt"
+	if query.Descending {
+		direction, comparison = -1, "$lt"
+	}
+
+	if !query.AfterID.IsZero() {
+		//ties on the sort field are broken by _id so pages never overlap or skip
+		filter["$or"] = bson.A{
+			bson.M{query.SortField: bson.M{comparison: query.AfterValue}},
+			bson.M{query.SortField: query.AfterValue, "_id": bson.M{comparison: query.AfterID}},
+		}
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: query.SortField, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(query.Limit)
+
+	files := []File{}
+	err := repo.retry.do(ctx, repo.logger, "list files", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &files)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong listing files", zap.Any("owner_id", query.OwnerID), zap.Error(err))
+		return nil, err
+	}
+	return files, nil
+}
+
+// UsageByOwner returns the bytes held by the owner's files, every version and the trash included
+func (repo *MongoFileRepository) UsageByOwner(ctx context.Context, ownerId primitive.ObjectID) (int64, error) {
+	//files uploaded before versioning have no versions, their size is the only one they hold
+	fileUsage := bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$versions", bson.A{}}}}, 0}},
+		bson.M{"$sum": "$versions.size"},
+		"$size",
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"owner_id": ownerId}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": fileUsage}}}},
+	}
+	var results []struct {
+		Total int64 `bson:"total"`
+	}
+	err := repo.retry.do(ctx, repo.logger, "aggregate owner usage", func(ctx context.Context) error {
+		cursor, err := repo.collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &results)
+	})
+	if err != nil {
+		repo.logger.Error("Failed to aggregate owner usage", zap.Any("owner_id", ownerId), zap.Error(err))
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Total, nil
+}
+
+// ChunkIDsByOwner returns the distinct ids of the chunks held by the owner's files, every version and the trash included
+func (repo *MongoFileRepository) ChunkIDsByOwner(ctx context.Context, ownerId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	//files uploaded before versioning have no versions, their chunk ids are the only ones they hold
+	fileChunkIds := bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$versions", bson.A{}}}}, 0}},
+		bson.M{"$reduce": bson.M{
+			"input":        "$versions.chunk_ids",
+			"initialValue": bson.A{},
+			"in":           bson.M{"$concatArrays": bson.A{"$$value", "$$this"}},
+		}},
+		bson.M{"$ifNull": bson.A{"$chunk_ids", bson.A{}}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"owner_id": ownerId}}},
+		{{Key: "$project", Value: bson.M{"chunk_id": fileChunkIds}}},
+		{{Key: "$unwind", Value: "$chunk_id"}},
+		{{Key: "$group", Value: bson.M{"_id": "$chunk_id"}}},
+	}
+	var results []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err := repo.retry.do(ctx, repo.logger, "aggregate owner chunks", func(ctx context.Context) error {
+		cursor, err := repo.collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &results)
+	})
+	if err != nil {
+		repo.logger.Error("Failed to aggregate owner chunks", zap.Any("owner_id", ownerId), zap.Error(err))
+		return nil, err
+	}
+	chunkIds := make([]primitive.ObjectID, 0, len(results))
+	for _, result := range results {
+		chunkIds = append(chunkIds, result.ID)
+	}
+	return chunkIds, nil
+}
+
+// SetCollaborator grants the collaborator's role on the file, replacing the role of an existing share with the same email
+// Retried as a whole, a retry after the share was added replaces it with the same role.
+func (repo *MongoFileRepository) SetCollaborator(ctx context.Context, fileDocumentId primitive.ObjectID, collaborator Collaborator) error {
+	return repo.retry.do(ctx, repo.logger, "set file share", func(ctx context.Context) error {
+		filter := bson.M{"_id": fileDocumentId, "deleted_at": nil, "collaborators.email": collaborator.Email}
+		update := bson.M{"$set": bson.M{"collaborators.$": collaborator}}
+		result, err := repo.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			repo.logger.Error("Failed to update file share", zap.Any("file_id", fileDocumentId), zap.String("email", collaborator.Email), zap.Error(err))
+			return err
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+
+		//not shared with the email yet, the filter keeps a concurrent share from adding it twice
+		filter = bson.M{"_id": fileDocumentId, "deleted_at": nil, "collaborators.email": bson.M{"$ne": collaborator.Email}}
+		update = bson.M{"$push": bson.M{"collaborators": collaborator}}
+		return repo.updateOne(ctx, filter, update)
+	})
+}
+
+// RemoveCollaborator revokes the share with the email, returns false if the file was not shared with it.
+// Not retried, a retry of a revocation that went through would report the file as not shared.
+func (repo *MongoFileRepository) RemoveCollaborator(ctx context.Context, fileDocumentId primitive.ObjectID, email string) (bool, error) {
+	filter := bson.M{"_id": fileDocumentId}
+	update := bson.M{"$pull": bson.M{"collaborators": bson.M{"email": email}}}
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		repo.logger.Error("Failed to remove file share", zap.Any("file_id", fileDocumentId), zap.String("email", email), zap.Error(err))
+		return false, wrapError(err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// ClaimShares records the user id on the pending shares made out to the email before the user signed in
+func (repo *MongoFileRepository) ClaimShares(ctx context.Context, userDocumentId primitive.ObjectID, email string) error {
+	filter := bson.M{"collaborators": bson.M{"$elemMatch": bson.M{"email": email, "user_id": bson.M{"$exists": false}}}}
+	update := bson.M{"$set": bson.M{"collaborators.$[share].user_id": userDocumentId}}
+	updateOptions := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"share.email": email, "share.user_id": bson.M{"$exists": false}}},
+	})
+	var claimed int64
+	err := repo.retry.do(ctx, repo.logger, "claim pending shares", func(ctx context.Context) error {
+		result, err := repo.collection.UpdateMany(ctx, filter, update, updateOptions)
+		if err != nil {
+			return err
+		}
+		claimed += result.ModifiedCount
+		return nil
+	})
+	if err != nil {
+		repo.logger.Error("Failed to claim pending shares", zap.String("email", email), zap.Error(err))
+		return err
+	}
+	if claimed > 0 {
+		repo.logger.Info("Claimed pending shares", zap.String("email", email), zap.Int64("files", claimed))
+	}
+	return nil
+}
+
+// ListSharedWith returns the files outside the trash shared with the user, most recently updated first
+func (repo *MongoFileRepository) ListSharedWith(ctx context.Context, userDocumentId primitive.ObjectID) ([]File, error) {
+	filter := bson.M{"collaborators.user_id": userDocumentId, "deleted_at": nil}
+	findOptions := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}})
+
+	files := []File{}
+	err := repo.retry.do(ctx, repo.logger, "list shared files", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &files)
+	})
 	if err != nil {
-		repo.logger.Error("Something went wrong getting user by object id", zap.Any("_id", insertResult.InsertedID))
-		return File{}, nil
+		repo.logger.Error("Something went wrong listing shared files", zap.Any("user_id", userDocumentId), zap.Error(err))
+		return nil, err
 	}
-	return insertedFile, nil
+	return files, nil
 }
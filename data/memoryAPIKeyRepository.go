@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryAPIKeyRepository keeps api keys in process memory with the semantics of MongoAPIKeyRepository, key hashes
+// included being unique. Keys are copied in and out, callers never share scopes with the store.
+type MemoryAPIKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[primitive.ObjectID]APIKey
+}
+
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{
+		keys: make(map[primitive.ObjectID]APIKey),
+	}
+}
+
+func (repo *MemoryAPIKeyRepository) Add(ctx context.Context, key APIKey) (APIKey, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if key.ID.IsZero() {
+		key.ID = primitive.NewObjectID()
+	}
+	for _, existing := range repo.keys {
+		if existing.ID == key.ID || existing.KeyHash == key.KeyHash {
+			return APIKey{}, ErrDuplicate
+		}
+	}
+	repo.keys[key.ID] = copyAPIKey(key)
+	return copyAPIKey(key), nil
+}
+
+func (repo *MemoryAPIKeyRepository) GetByHash(ctx context.Context, keyHash string) (APIKey, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, key := range repo.keys {
+		if key.KeyHash == keyHash {
+			return copyAPIKey(key), nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+func (repo *MemoryAPIKeyRepository) ListByOwner(ctx context.Context, ownerEmail string) ([]APIKey, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, key := range repo.keys {
+		if key.OwnerEmail == ownerEmail {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (repo *MemoryAPIKeyRepository) Revoke(ctx context.Context, keyId primitive.ObjectID, ownerEmail string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	key, ok := repo.keys[keyId]
+	if !ok || key.OwnerEmail != ownerEmail {
+		return ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		repo.keys[keyId] = key
+	}
+	return nil
+}
+
+func (repo *MemoryAPIKeyRepository) TouchLastUsed(ctx context.Context, keyId primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if key, ok := repo.keys[keyId]; ok {
+		now := time.Now()
+		key.LastUsedAt = &now
+		repo.keys[keyId] = key
+	}
+	return nil
+}
+
+func copyAPIKey(key APIKey) APIKey {
+	if key.Scopes != nil {
+		key.Scopes = append([]string{}, key.Scopes...)
+	}
+	return key
+}
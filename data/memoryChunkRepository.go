@@ -0,0 +1,228 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryChunkRepository keeps chunks in process memory with the semantics of MongoChunkRepository,
+// every chunk being reference counted
+type MemoryChunkRepository struct {
+	mu       sync.RWMutex
+	chunks   map[primitive.ObjectID]Chunk
+	byHash   map[string]primitive.ObjectID
+	released map[string]time.Time
+}
+
+func NewMemoryChunkRepository() *MemoryChunkRepository {
+	return &MemoryChunkRepository{
+		chunks:   make(map[primitive.ObjectID]Chunk),
+		byHash:   make(map[string]primitive.ObjectID),
+		released: make(map[string]time.Time),
+	}
+}
+
+func (repo *MemoryChunkRepository) Acquire(ctx context.Context, hash string, size int64, digest string) (Chunk, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	id, ok := repo.byHash[hash]
+	if !ok {
+		id = primitive.NewObjectID()
+		repo.byHash[hash] = id
+		repo.chunks[id] = Chunk{ID: id, Hash: hash, Size: size}
+	}
+	chunk := repo.chunks[id]
+	chunk.RefCount++
+	if len(digest) > 0 {
+		chunk.SHA256 = digest
+	}
+	repo.chunks[id] = chunk
+	return chunk, nil
+}
+
+func (repo *MemoryChunkRepository) AddReferences(ctx context.Context, chunkIds []primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, count := range countIds(chunkIds) {
+		if chunk, ok := repo.chunks[id]; ok {
+			chunk.RefCount += count
+			repo.chunks[id] = chunk
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryChunkRepository) Release(ctx context.Context, chunkIds []primitive.ObjectID) ([]Chunk, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	released := []Chunk{}
+	for id, count := range countIds(chunkIds) {
+		chunk, ok := repo.chunks[id]
+		if !ok {
+			continue
+		}
+		chunk.RefCount -= count
+		if chunk.RefCount > 0 {
+			repo.chunks[id] = chunk
+			continue
+		}
+		delete(repo.chunks, id)
+		delete(repo.byHash, chunk.Hash)
+		released = append(released, chunk)
+	}
+	return released, nil
+}
+
+func (repo *MemoryChunkRepository) AddReleased(ctx context.Context, hashes []string, releasedAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, hash := range hashes {
+		if _, ok := repo.released[hash]; !ok {
+			repo.released[hash] = releasedAt
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryChunkRepository) ListReleased(ctx context.Context, afterHash string, limit int64) ([]ReleasedContent, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	released := []ReleasedContent{}
+	for hash, releasedAt := range repo.released {
+		if hash > afterHash {
+			released = append(released, ReleasedContent{Hash: hash, ReleasedAt: releasedAt})
+		}
+	}
+	sort.Slice(released, func(i, j int) bool {
+		return released[i].Hash < released[j].Hash
+	})
+	if limit > 0 && int64(len(released)) > limit {
+		released = released[:limit]
+	}
+	return released, nil
+}
+
+func (repo *MemoryChunkRepository) RemoveReleased(ctx context.Context, hash string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.released, hash)
+	return nil
+}
+
+func (repo *MemoryChunkRepository) ListUnreferenced(ctx context.Context, cutoff time.Time, afterId primitive.ObjectID, limit int64) ([]Chunk, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	chunks := []Chunk{}
+	for id, chunk := range repo.chunks {
+		if chunk.RefCount > 0 || !id.Timestamp().Before(cutoff) {
+			continue
+		}
+		if afterId.IsZero() || compareIds(id, afterId) > 0 {
+			chunks = append(chunks, chunk)
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return compareIds(chunks[i].ID, chunks[j].ID) < 0
+	})
+	if limit > 0 && int64(len(chunks)) > limit {
+		chunks = chunks[:limit]
+	}
+	return chunks, nil
+}
+
+func (repo *MemoryChunkRepository) DeleteUnreferenced(ctx context.Context, chunkId primitive.ObjectID) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	chunk, ok := repo.chunks[chunkId]
+	if !ok || chunk.RefCount > 0 {
+		return false, nil
+	}
+	delete(repo.chunks, chunkId)
+	delete(repo.byHash, chunk.Hash)
+	return true, nil
+}
+
+func (repo *MemoryChunkRepository) ListUnverified(ctx context.Context, verifiedBefore time.Time, afterId primitive.ObjectID, limit int64) ([]Chunk, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	chunks := []Chunk{}
+	for id, chunk := range repo.chunks {
+		if chunk.VerifiedAt != nil && !chunk.VerifiedAt.Before(verifiedBefore) {
+			continue
+		}
+		if afterId.IsZero() || compareIds(id, afterId) > 0 {
+			chunks = append(chunks, chunk)
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return compareIds(chunks[i].ID, chunks[j].ID) < 0
+	})
+	if limit > 0 && int64(len(chunks)) > limit {
+		chunks = chunks[:limit]
+	}
+	return chunks, nil
+}
+
+func (repo *MemoryChunkRepository) SetVerification(ctx context.Context, chunkId primitive.ObjectID, status string, verifiedAt time.Time, digest string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if chunk, ok := repo.chunks[chunkId]; ok {
+		chunk.Status = status
+		chunk.VerifiedAt = &verifiedAt
+		if len(digest) > 0 {
+			chunk.SHA256 = digest
+		}
+		repo.chunks[chunkId] = chunk
+	}
+	return nil
+}
+
+func (repo *MemoryChunkRepository) GetMany(ctx context.Context, chunkIds []primitive.ObjectID) ([]Chunk, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	chunks := make([]Chunk, 0, len(chunkIds))
+	for _, id := range chunkIds {
+		chunk, ok := repo.chunks[id]
+		if !ok {
+			return nil, ErrChunkNotFound
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+func (repo *MemoryChunkRepository) IsReferenced(ctx context.Context, hash string) (bool, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	id, ok := repo.byHash[hash]
+	return ok && repo.chunks[id].RefCount > 0, nil
+}
+
+func (repo *MemoryChunkRepository) Stats(ctx context.Context) (ChunkStats, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	stats := ChunkStats{}
+	for _, chunk := range repo.chunks {
+		stats.Chunks++
+		stats.StoredBytes += chunk.Size
+		stats.ReferencedBytes += chunk.Size * chunk.RefCount
+	}
+	return stats, nil
+}
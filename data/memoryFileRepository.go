@@ -0,0 +1,368 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/utility"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryFileRepository keeps files in process memory with the semantics of MongoFileRepository. Files are copied
+// in and out, callers never share slices with the store.
+type MemoryFileRepository struct {
+	mu    sync.RWMutex
+	files map[primitive.ObjectID]File
+}
+
+func NewMemoryFileRepository() *MemoryFileRepository {
+	return &MemoryFileRepository{
+		files: make(map[primitive.ObjectID]File),
+	}
+}
+
+func (repo *MemoryFileRepository) Add(ctx context.Context, file File) (File, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if file.ID.IsZero() {
+		file.ID = primitive.NewObjectID()
+	}
+	if _, ok := repo.files[file.ID]; ok {
+		return File{}, ErrDuplicate
+	}
+	repo.files[file.ID] = copyFile(file)
+	return copyFile(file), nil
+}
+
+func (repo *MemoryFileRepository) Get(ctx context.Context, fileDocumentId primitive.ObjectID) (File, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	file, ok := repo.files[fileDocumentId]
+	if !ok {
+		return File{}, ErrFileNotFound
+	}
+	return copyFile(file), nil
+}
+
+func (repo *MemoryFileRepository) GetDeleted(ctx context.Context, fileDocumentIds []primitive.ObjectID) ([]File, error) {
+	return repo.find(func(file File) bool {
+		return file.DeletedAt != nil && utility.ContainsId(fileDocumentIds, file.ID)
+	}), nil
+}
+
+func (repo *MemoryFileRepository) GetDeletedBefore(ctx context.Context, cutoff time.Time) ([]File, error) {
+	return repo.find(func(file File) bool {
+		return file.DeletedAt != nil && file.DeletedAt.Before(cutoff)
+	}), nil
+}
+
+func (repo *MemoryFileRepository) find(match func(file File) bool) []File {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	files := []File{}
+	for _, file := range repo.files {
+		if match(file) {
+			files = append(files, copyFile(file))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return compareIds(files[i].ID, files[j].ID) < 0
+	})
+	return files
+}
+
+func (repo *MemoryFileRepository) SoftDelete(ctx context.Context, fileDocumentId primitive.ObjectID) error {
+	return repo.update(fileDocumentId, func(file *File) bool {
+		if file.DeletedAt != nil {
+			return false
+		}
+		deletedAt := time.Now()
+		file.DeletedAt = &deletedAt
+		return true
+	})
+}
+
+func (repo *MemoryFileRepository) Restore(ctx context.Context, fileDocumentId primitive.ObjectID) error {
+	return repo.update(fileDocumentId, func(file *File) bool {
+		if file.DeletedAt == nil {
+			return false
+		}
+		file.DeletedAt = nil
+		return true
+	})
+}
+
+// update applies change to the file, change returns false if the file does not match the update's filter
+func (repo *MemoryFileRepository) update(fileDocumentId primitive.ObjectID, change func(file *File) bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	file, ok := repo.files[fileDocumentId]
+	if !ok {
+		return ErrFileNotFound.WithMessage("no file matched the update")
+	}
+	file = copyFile(file)
+	if !change(&file) {
+		return ErrFileNotFound.WithMessage("no file matched the update")
+	}
+	repo.files[fileDocumentId] = file
+	return nil
+}
+
+func (repo *MemoryFileRepository) Delete(ctx context.Context, fileDocumentId primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.files, fileDocumentId)
+	return nil
+}
+
+func (repo *MemoryFileRepository) SetVersions(ctx context.Context, fileDocumentId primitive.ObjectID, expectedVersion int, versions []FileVersion) error {
+	err := repo.update(fileDocumentId, func(file *File) bool {
+		if file.DeletedAt != nil || file.Version != expectedVersion {
+			return false
+		}
+		current := versions[len(versions)-1]
+		file.Version = current.Number
+		file.ChunkIDs = current.ChunkIDs
+		file.Size = current.Size
+		file.Hash = current.Hash
+		file.MD5 = current.MD5
+		file.Versions = versions
+		file.UpdatedAt = current.CreatedAt
+		*file = copyFile(*file)
+		return true
+	})
+	if err != nil {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (repo *MemoryFileRepository) List(ctx context.Context, query FileListQuery) ([]File, error) {
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+	//same order as the Mongo sort, the sort field then _id, both in the query's direction
+	compare := func(file File, value interface{}, id primitive.ObjectID) int {
+		if byField := compareSortValue(file, query.SortField, value); byField != 0 {
+			return byField * direction
+		}
+		return compareIds(file.ID, id) * direction
+	}
+
+	files := repo.find(func(file File) bool {
+		if file.OwnerID != query.OwnerID || file.DeletedAt != nil {
+			return false
+		}
+		if len(query.Type) > 0 && file.Type != query.Type {
+			return false
+		}
+		return query.AfterID.IsZero() || compare(file, query.AfterValue, query.AfterID) > 0
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return compare(files[i], sortValue(files[j], query.SortField), files[j].ID) < 0
+	})
+	if query.Limit > 0 && int64(len(files)) > query.Limit {
+		files = files[:query.Limit]
+	}
+	return files, nil
+}
+
+func (repo *MemoryFileRepository) ListAll(ctx context.Context, afterId primitive.ObjectID, limit int64) ([]File, error) {
+	files := repo.find(func(file File) bool {
+		return afterId.IsZero() || compareIds(file.ID, afterId) > 0
+	})
+	if limit > 0 && int64(len(files)) > limit {
+		files = files[:limit]
+	}
+	return files, nil
+}
+
+func (repo *MemoryFileRepository) UsageByOwner(ctx context.Context, ownerId primitive.ObjectID) (int64, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var usage int64
+	for _, file := range repo.files {
+		if file.OwnerID != ownerId {
+			continue
+		}
+		if len(file.Versions) == 0 {
+			usage += file.Size
+			continue
+		}
+		for _, version := range file.Versions {
+			usage += version.Size
+		}
+	}
+	return usage, nil
+}
+
+func (repo *MemoryFileRepository) ChunkIDsByOwner(ctx context.Context, ownerId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	chunkIds := []primitive.ObjectID{}
+	for _, file := range repo.files {
+		if file.OwnerID != ownerId {
+			continue
+		}
+		if len(file.Versions) == 0 {
+			chunkIds = utility.UnionOfIds(chunkIds, file.ChunkIDs)
+			continue
+		}
+		for _, version := range file.Versions {
+			chunkIds = utility.UnionOfIds(chunkIds, version.ChunkIDs)
+		}
+	}
+	return chunkIds, nil
+}
+
+func (repo *MemoryFileRepository) SetCollaborator(ctx context.Context, fileDocumentId primitive.ObjectID, collaborator Collaborator) error {
+	return repo.update(fileDocumentId, func(file *File) bool {
+		if file.DeletedAt != nil {
+			return false
+		}
+		for i, existing := range file.Collaborators {
+			if existing.Email == collaborator.Email {
+				file.Collaborators[i] = collaborator
+				return true
+			}
+		}
+		file.Collaborators = append(file.Collaborators, collaborator)
+		return true
+	})
+}
+
+func (repo *MemoryFileRepository) RemoveCollaborator(ctx context.Context, fileDocumentId primitive.ObjectID, email string) (bool, error) {
+	removed := false
+	err := repo.update(fileDocumentId, func(file *File) bool {
+		collaborators := []Collaborator{}
+		for _, existing := range file.Collaborators {
+			if existing.Email == email {
+				removed = true
+				continue
+			}
+			collaborators = append(collaborators, existing)
+		}
+		file.Collaborators = collaborators
+		return true
+	})
+	if err != nil {
+		return false, nil
+	}
+	return removed, nil
+}
+
+func (repo *MemoryFileRepository) ClaimShares(ctx context.Context, userDocumentId primitive.ObjectID, email string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, file := range repo.files {
+		file = copyFile(file)
+		claimed := false
+		for i, collaborator := range file.Collaborators {
+			if collaborator.Email == email && collaborator.UserID.IsZero() {
+				file.Collaborators[i].UserID = userDocumentId
+				claimed = true
+			}
+		}
+		if claimed {
+			repo.files[id] = file
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryFileRepository) ListSharedWith(ctx context.Context, userDocumentId primitive.ObjectID) ([]File, error) {
+	files := repo.find(func(file File) bool {
+		if file.DeletedAt != nil {
+			return false
+		}
+		for _, collaborator := range file.Collaborators {
+			if collaborator.UserID == userDocumentId {
+				return true
+			}
+		}
+		return false
+	})
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].UpdatedAt.Equal(files[j].UpdatedAt) {
+			return files[i].UpdatedAt.After(files[j].UpdatedAt)
+		}
+		return compareIds(files[i].ID, files[j].ID) > 0
+	})
+	return files, nil
+}
+
+func sortValue(file File, field string) interface{} {
+	switch field {
+	case "name":
+		return file.Name
+	case "size":
+		return file.Size
+	case "created_at":
+		return file.CreatedAt
+	case "updated_at":
+		return file.UpdatedAt
+	}
+	return nil
+}
+
+// compareSortValue compares the file's value of the sort field with a value of the same field
+func compareSortValue(file File, field string, value interface{}) int {
+	switch current := sortValue(file, field).(type) {
+	case string:
+		return strings.Compare(current, value.(string))
+	case int64:
+		other := value.(int64)
+		if current < other {
+			return -1
+		}
+		if current > other {
+			return 1
+		}
+	case time.Time:
+		other := value.(time.Time)
+		if current.Before(other) {
+			return -1
+		}
+		if current.After(other) {
+			return 1
+		}
+	}
+	return 0
+}
+
+func compareIds(a primitive.ObjectID, b primitive.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+func copyFile(file File) File {
+	file.ChunkIDs = append([]primitive.ObjectID{}, file.ChunkIDs...)
+	if file.Versions != nil {
+		versions := make([]FileVersion, len(file.Versions))
+		for i, version := range file.Versions {
+			version.ChunkIDs = append([]primitive.ObjectID{}, version.ChunkIDs...)
+			versions[i] = version
+		}
+		file.Versions = versions
+	}
+	if file.Collaborators != nil {
+		file.Collaborators = append([]Collaborator{}, file.Collaborators...)
+	}
+	if file.DeletedAt != nil {
+		deletedAt := *file.DeletedAt
+		file.DeletedAt = &deletedAt
+	}
+	return file
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryShareLinkRepository keeps share links in process memory with the semantics of MongoShareLinkRepository,
+// token hashes included being unique
+type MemoryShareLinkRepository struct {
+	mu    sync.RWMutex
+	links map[primitive.ObjectID]ShareLink
+}
+
+func NewMemoryShareLinkRepository() *MemoryShareLinkRepository {
+	return &MemoryShareLinkRepository{
+		links: make(map[primitive.ObjectID]ShareLink),
+	}
+}
+
+func (repo *MemoryShareLinkRepository) Add(ctx context.Context, link ShareLink) (ShareLink, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if link.ID.IsZero() {
+		link.ID = primitive.NewObjectID()
+	}
+	for _, existing := range repo.links {
+		if existing.ID == link.ID || existing.TokenHash == link.TokenHash {
+			return ShareLink{}, ErrDuplicate
+		}
+	}
+	repo.links[link.ID] = link
+	return link, nil
+}
+
+func (repo *MemoryShareLinkRepository) GetByHash(ctx context.Context, tokenHash string) (ShareLink, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, link := range repo.links {
+		if link.TokenHash == tokenHash {
+			return link, nil
+		}
+	}
+	return ShareLink{}, ErrShareLinkNotFound
+}
+
+func (repo *MemoryShareLinkRepository) ListByFile(ctx context.Context, fileDocumentId primitive.ObjectID) ([]ShareLink, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	links := []ShareLink{}
+	for _, link := range repo.links {
+		if link.FileID == fileDocumentId {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+func (repo *MemoryShareLinkRepository) Revoke(ctx context.Context, linkId primitive.ObjectID, fileDocumentId primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	link, ok := repo.links[linkId]
+	if !ok || link.FileID != fileDocumentId {
+		return ErrShareLinkNotFound
+	}
+	if link.RevokedAt == nil {
+		now := time.Now()
+		link.RevokedAt = &now
+		repo.links[linkId] = link
+	}
+	return nil
+}
+
+func (repo *MemoryShareLinkRepository) ClaimDownload(ctx context.Context, linkId primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	link, ok := repo.links[linkId]
+	if !ok || (link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads) {
+		return ErrShareLinkExhausted
+	}
+	link.Downloads++
+	repo.links[linkId] = link
+	return nil
+}
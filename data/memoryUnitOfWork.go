@@ -0,0 +1,15 @@
+package data
+
+import "context"
+
+// MemoryUnitOfWork runs work without a transaction, for use with the in-memory repositories. Writes made before
+// work fails are not rolled back.
+type MemoryUnitOfWork struct{}
+
+func NewMemoryUnitOfWork() *MemoryUnitOfWork {
+	return &MemoryUnitOfWork{}
+}
+
+func (uow *MemoryUnitOfWork) Do(ctx context.Context, work func(ctx context.Context) error) error {
+	return work(ctx)
+}
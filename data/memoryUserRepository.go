@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/utility"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUserRepository keeps users in process memory with the semantics of MongoUserRepository, emails included
+// being unique. Users are copied in and out, callers never share slices with the store.
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]User
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users: make(map[primitive.ObjectID]User),
+	}
+}
+
+func (repo *MemoryUserRepository) Add(ctx context.Context, user *User) (User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	for _, existing := range repo.users {
+		if existing.ID == user.ID || existing.Email == user.Email {
+			return User{}, ErrDuplicate
+		}
+	}
+	repo.users[user.ID] = copyUser(*user)
+	return copyUser(*user), nil
+}
+
+func (repo *MemoryUserRepository) Get(ctx context.Context, email string) (User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	for _, user := range repo.users {
+		if user.Email == email {
+			return copyUser(user), nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (repo *MemoryUserRepository) GetByID(ctx context.Context, userDocumentId primitive.ObjectID) (User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	user, ok := repo.users[userDocumentId]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+// Update merges the file ids into the user's files, same as MongoUserRepository.Update
+func (repo *MemoryUserRepository) Update(ctx context.Context, userDocumentId primitive.ObjectID, updateObject User) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	user, ok := repo.users[userDocumentId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.LastAccessedOn = time.Now()
+	user.Files = utility.UnionOfIds(user.Files, updateObject.Files)
+	repo.users[userDocumentId] = user
+	return nil
+}
+
+func (repo *MemoryUserRepository) RemoveFile(ctx context.Context, fileDocumentId primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, user := range repo.users {
+		if !utility.ContainsId(user.Files, fileDocumentId) {
+			continue
+		}
+		files := []primitive.ObjectID{}
+		for _, fileId := range user.Files {
+			if fileId != fileDocumentId {
+				files = append(files, fileId)
+			}
+		}
+		user.Files = files
+		repo.users[id] = user
+	}
+	return nil
+}
+
+func (repo *MemoryUserRepository) List(ctx context.Context, afterId primitive.ObjectID, limit int64) ([]User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	users := []User{}
+	for _, user := range repo.users {
+		if afterId.IsZero() || compareIds(user.ID, afterId) > 0 {
+			users = append(users, copyUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return compareIds(users[i].ID, users[j].ID) < 0
+	})
+	if limit > 0 && int64(len(users)) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (repo *MemoryUserRepository) SetQuota(ctx context.Context, email string, quotaBytes int64) error {
+	return repo.updateByEmail(email, func(user *User) {
+		user.QuotaBytes = quotaBytes
+	})
+}
+
+func (repo *MemoryUserRepository) AddRole(ctx context.Context, email string, role string) error {
+	return repo.updateByEmail(email, func(user *User) {
+		for _, existing := range user.Roles {
+			if existing == role {
+				return
+			}
+		}
+		user.Roles = append(user.Roles, role)
+	})
+}
+
+func (repo *MemoryUserRepository) updateByEmail(email string, update func(user *User)) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, user := range repo.users {
+		if user.Email == email {
+			update(&user)
+			repo.users[id] = user
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func copyUser(user User) User {
+	user.Files = append([]primitive.ObjectID{}, user.Files...)
+	if user.Roles != nil {
+		user.Roles = append([]string{}, user.Roles...)
+	}
+	return user
+}
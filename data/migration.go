@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// migration is a one-time change to existing documents. Applied migrations are recorded by name in the migration
+// collection. A crash between applying a migration and recording it runs it again, so every migration is idempotent.
+type migration struct {
+	name  string
+	apply func(ctx context.Context, db *mongo.Database, logger *zap.Logger) error
+}
+
+// migrations run in order, later ones may rely on earlier ones
+var migrations = []migration{
+	{name: "merge_legacy_user_fields", apply: mergeLegacyUserFields},
+	{name: "backfill_file_owner", apply: backfillFileOwner},
+}
+
+type migrationRecord struct {
+	Name      string    `bson:"_id"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Migrate applies the migrations not applied yet. Runs on startup before anything reads or removes documents,
+// background workers included.
+func (db *MongoDB) Migrate(ctx context.Context, logger *zap.Logger) error {
+	database := db.GetDatabase()
+	records := database.Collection("migration")
+
+	for _, m := range migrations {
+		err := records.FindOne(ctx, bson.M{"_id": m.name}).Err()
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			logger.Error("Failed to look up migration", zap.String("migration", m.name), zap.Error(err))
+			return wrapError(err)
+		}
+
+		logger.Info("Applying migration", zap.String("migration", m.name))
+		if err := m.apply(ctx, database, logger); err != nil {
+			logger.Error("Failed to apply migration", zap.String("migration", m.name), zap.Error(err))
+			return wrapError(err)
+		}
+		if _, err := records.InsertOne(ctx, migrationRecord{Name: m.name, AppliedAt: time.Now()}); err != nil && !mongo.IsDuplicateKeyError(err) {
+			logger.Error("Failed to record migration", zap.String("migration", m.name), zap.Error(err))
+			return wrapError(err)
+		}
+	}
+	return nil
+}
+
+// mergeLegacyUserFields moves the file ids and access time that user updates used to write under "Files" and
+// "LastAccessedOn" into "files" and "last_accessed_on", the only keys read. Files listed under either key are kept.
+func mergeLegacyUserFields(ctx context.Context, db *mongo.Database, logger *zap.Logger) error {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"Files": bson.M{"$exists": true}},
+		bson.M{"LastAccessedOn": bson.M{"$exists": true}},
+		bson.M{"files": nil}, //missing or null, $addToSet needs an array
+	}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"files":            bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$files", bson.A{}}}, bson.M{"$ifNull": bson.A{"$Files", bson.A{}}}}},
+			"last_accessed_on": bson.M{"$max": bson.A{"$last_accessed_on", "$LastAccessedOn"}},
+		}}},
+		{{Key: "$unset", Value: bson.A{"Files", "LastAccessedOn"}}},
+	}
+
+	result, err := db.Collection("user").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	logger.Info("Merged legacy user fields", zap.Int64("users", result.ModifiedCount))
+	return nil
+}
+
+// backfillFileOwner records the owner on files uploaded before files carried an owner_id, the user listing the file
+func backfillFileOwner(ctx context.Context, db *mongo.Database, logger *zap.Logger) error {
+	filter := bson.M{"files.0": bson.M{"$exists": true}}
+	cursor, err := db.Collection("user").Find(ctx, filter, options.Find().SetProjection(bson.M{"files": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var backfilled int64
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		fileFilter := bson.M{"_id": bson.M{"$in": user.Files}, "owner_id": bson.M{"$exists": false}}
+		result, err := db.Collection("file").UpdateMany(ctx, fileFilter, bson.M{"$set": bson.M{"owner_id": user.ID}})
+		if err != nil {
+			return err
+		}
+		backfilled += result.ModifiedCount
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	logger.Info("Backfilled owner on files", zap.Int64("files", backfilled))
+	return nil
+}
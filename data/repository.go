@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Services depend on these interfaces rather than on the Mongo repositories, the in-memory implementations stand
+// in for MongoDB in tests. Not found errors match ErrNotFound, see errors.go for the others.
+
+type UserRepository interface {
+	Add(ctx context.Context, user *User) (User, error)
+	Get(ctx context.Context, email string) (User, error)
+	GetByID(ctx context.Context, userDocumentId primitive.ObjectID) (User, error)
+	Update(ctx context.Context, userDocumentId primitive.ObjectID, updateObject User) error
+	RemoveFile(ctx context.Context, fileDocumentId primitive.ObjectID) error
+	List(ctx context.Context, afterId primitive.ObjectID, limit int64) ([]User, error)
+	SetQuota(ctx context.Context, email string, quotaBytes int64) error
+	AddRole(ctx context.Context, email string, role string) error
+}
+
+type FileRepository interface {
+	Add(ctx context.Context, file File) (File, error)
+	Get(ctx context.Context, fileDocumentId primitive.ObjectID) (File, error)
+	GetDeleted(ctx context.Context, fileDocumentIds []primitive.ObjectID) ([]File, error)
+	GetDeletedBefore(ctx context.Context, cutoff time.Time) ([]File, error)
+	SoftDelete(ctx context.Context, fileDocumentId primitive.ObjectID) error
+	Restore(ctx context.Context, fileDocumentId primitive.ObjectID) error
+	Delete(ctx context.Context, fileDocumentId primitive.ObjectID) error
+	SetVersions(ctx context.Context, fileDocumentId primitive.ObjectID, expectedVersion int, versions []FileVersion) error
+	List(ctx context.Context, query FileListQuery) ([]File, error)
+	ListAll(ctx context.Context, afterId primitive.ObjectID, limit int64) ([]File, error)
+	UsageByOwner(ctx context.Context, ownerId primitive.ObjectID) (int64, error)
+	ChunkIDsByOwner(ctx context.Context, ownerId primitive.ObjectID) ([]primitive.ObjectID, error)
+	SetCollaborator(ctx context.Context, fileDocumentId primitive.ObjectID, collaborator Collaborator) error
+	RemoveCollaborator(ctx context.Context, fileDocumentId primitive.ObjectID, email string) (bool, error)
+	ClaimShares(ctx context.Context, userDocumentId primitive.ObjectID, email string) error
+	ListSharedWith(ctx context.Context, userDocumentId primitive.ObjectID) ([]File, error)
+}
+
+type ChunkRepository interface {
+	Acquire(ctx context.Context, hash string, size int64, digest string) (Chunk, error)
+	AddReferences(ctx context.Context, chunkIds []primitive.ObjectID) error
+	Release(ctx context.Context, chunkIds []primitive.ObjectID) ([]Chunk, error)
+	AddReleased(ctx context.Context, hashes []string, releasedAt time.Time) error
+	ListReleased(ctx context.Context, afterHash string, limit int64) ([]ReleasedContent, error)
+	RemoveReleased(ctx context.Context, hash string) error
+	GetMany(ctx context.Context, chunkIds []primitive.ObjectID) ([]Chunk, error)
+	IsReferenced(ctx context.Context, hash string) (bool, error)
+	Stats(ctx context.Context) (ChunkStats, error)
+	ListUnreferenced(ctx context.Context, cutoff time.Time, afterId primitive.ObjectID, limit int64) ([]Chunk, error)
+	DeleteUnreferenced(ctx context.Context, chunkId primitive.ObjectID) (bool, error)
+	ListUnverified(ctx context.Context, verifiedBefore time.Time, afterId primitive.ObjectID, limit int64) ([]Chunk, error)
+	SetVerification(ctx context.Context, chunkId primitive.ObjectID, status string, verifiedAt time.Time, digest string) error
+}
+
+type ShareLinkRepository interface {
+	Add(ctx context.Context, link ShareLink) (ShareLink, error)
+	GetByHash(ctx context.Context, tokenHash string) (ShareLink, error)
+	ListByFile(ctx context.Context, fileDocumentId primitive.ObjectID) ([]ShareLink, error)
+	Revoke(ctx context.Context, linkId primitive.ObjectID, fileDocumentId primitive.ObjectID) error
+	ClaimDownload(ctx context.Context, linkId primitive.ObjectID) error
+}
+
+type APIKeyRepository interface {
+	Add(ctx context.Context, key APIKey) (APIKey, error)
+	GetByHash(ctx context.Context, keyHash string) (APIKey, error)
+	ListByOwner(ctx context.Context, ownerEmail string) ([]APIKey, error)
+	Revoke(ctx context.Context, keyId primitive.ObjectID, ownerEmail string) error
+	TouchLastUsed(ctx context.Context, keyId primitive.ObjectID) error
+}
+
+// UnitOfWork runs work as a single transaction, see MongoUnitOfWork
+type UnitOfWork interface {
+	Do(ctx context.Context, work func(ctx context.Context) error) error
+}
+
+var (
+	_ UserRepository      = (*MongoUserRepository)(nil)
+	_ FileRepository      = (*MongoFileRepository)(nil)
+	_ ChunkRepository     = (*MongoChunkRepository)(nil)
+	_ ShareLinkRepository = (*MongoShareLinkRepository)(nil)
+	_ APIKeyRepository    = (*MongoAPIKeyRepository)(nil)
+	_ UnitOfWork          = (*MongoUnitOfWork)(nil)
+	_ UserRepository      = (*MemoryUserRepository)(nil)
+	_ FileRepository      = (*MemoryFileRepository)(nil)
+	_ ChunkRepository     = (*MemoryChunkRepository)(nil)
+	_ ShareLinkRepository = (*MemoryShareLinkRepository)(nil)
+	_ APIKeyRepository    = (*MemoryAPIKeyRepository)(nil)
+	_ UnitOfWork          = (*MemoryUnitOfWork)(nil)
+)
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultRetryAttempts  = 3
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	DefaultRetryMaxDelay  = 2 * time.Second
+)
+
+// retryPolicy retries operations failing with ErrTransient, backing off exponentially with jitter between attempts.
+// Repositories run every read and every write that can safely run twice through it. Writes a retry cannot tell from
+// a conflict, e.g. reference counts and conditional updates, run once and say so in their doc comment.
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// newRetryPolicyFromConfig reads database.retry.attempts, base_delay and max_delay, defaults apply to unset values
+func newRetryPolicyFromConfig() retryPolicy {
+	policy := retryPolicy{
+		attempts:  viper.GetInt("database.retry.attempts"),
+		baseDelay: viper.GetDuration("database.retry.base_delay"),
+		maxDelay:  viper.GetDuration("database.retry.max_delay"),
+	}
+	if policy.attempts <= 0 {
+		policy.attempts = DefaultRetryAttempts
+	}
+	if policy.baseDelay <= 0 {
+		policy.baseDelay = DefaultRetryBaseDelay
+	}
+	if policy.maxDelay <= 0 {
+		policy.maxDelay = DefaultRetryMaxDelay
+	}
+	return policy
+}
+
+// do runs operation until it succeeds, fails with a non transient error or runs out of attempts. The returned
+// error is classified with wrapError. Inside a transaction operation runs once, UnitOfWork retries the whole
+// transaction instead.
+func (policy retryPolicy) do(ctx context.Context, logger *zap.Logger, name string, operation func(ctx context.Context) error) error {
+	attempts := policy.attempts
+	if mongo.SessionFromContext(ctx) != nil {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = wrapError(operation(ctx))
+		if err == nil || !errors.Is(err, ErrTransient) || attempt >= attempts {
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		logger.Warn("Retrying database operation", zap.String("operation", name), zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff doubles the base delay per attempt up to the max delay, and picks a random delay up to that
+func (policy retryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.maxDelay
+	if attempt < 32 && policy.baseDelay<<(attempt-1) < policy.maxDelay {
+		delay = policy.baseDelay << (attempt - 1)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
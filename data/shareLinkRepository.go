@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+)
+
+// ShareLink gives anyone holding its token access to a file without an account.
+// Only the SHA-256 of the token is stored and the password, if any, as a bcrypt hash.
+type ShareLink struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	FileID       primitive.ObjectID `bson:"file_id"`
+	CreatedBy    string             `bson:"created_by"`
+	Prefix       string             `bson:"prefix"` //first characters of the token, to tell links apart
+	TokenHash    string             `bson:"token_hash"`
+	PasswordHash string             `bson:"password_hash,omitempty"`
+	ExpiresAt    *time.Time         `bson:"expires_at,omitempty"`
+	MaxDownloads int                `bson:"max_downloads,omitempty"` //0 means unlimited
+	Downloads    int                `bson:"downloads"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	RevokedAt    *time.Time         `bson:"revoked_at,omitempty"`
+}
+
+var (
+	ErrShareLinkNotFound  = apperror.NotFound("share_link_not_found", "share link not found").Wrap(ErrNotFound)
+	ErrShareLinkExhausted = apperror.New(apperror.KindGone, "share_link_exhausted", "share link download limit reached")
+)
+
+type MongoShareLinkRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	retry      retryPolicy
+}
+
+func NewMongoShareLinkRepository(db *MongoDB, logger *zap.Logger) *MongoShareLinkRepository {
+	return &MongoShareLinkRepository{
+		collection: db.GetDatabase().Collection("share_link"),
+		logger:     logger,
+		retry:      db.retry,
+	}
+}
+
+func (repo *MongoShareLinkRepository) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "file_id", Value: 1}, {Key: "created_at", Value: 1}}},
+	}
+	_, err := repo.collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		repo.logger.Error("Failed to create share link indexes", zap.Error(err))
+		return wrapError(err)
+	}
+	return nil
+}
+
+func (repo *MongoShareLinkRepository) Add(ctx context.Context, link ShareLink) (ShareLink, error) {
+	if link.ID.IsZero() {
+		link.ID = primitive.NewObjectID()
+	}
+	err := repo.retry.do(ctx, repo.logger, "add share link", func(ctx context.Context) error {
+		return insertOnce(ctx, repo.collection, link.ID, link)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong creating the share link", zap.Error(err))
+		return ShareLink{}, err
+	}
+	repo.logger.Info("Created a new share link successfully", zap.Any("objectId", link.ID), zap.Any("file_id", link.FileID))
+	return link, nil
+}
+
+// GetByHash fails with ErrShareLinkNotFound if no link has the token hash, revoked links are returned
+func (repo *MongoShareLinkRepository) GetByHash(ctx context.Context, tokenHash string) (ShareLink, error) {
+	var link ShareLink
+	err := repo.retry.do(ctx, repo.logger, "get share link", func(ctx context.Context) error {
+		return repo.collection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&link)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return ShareLink{}, ErrShareLinkNotFound
+	}
+	if err != nil {
+		repo.logger.Error("Something went wrong getting share link", zap.Error(err))
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+func (repo *MongoShareLinkRepository) ListByFile(ctx context.Context, fileDocumentId primitive.ObjectID) ([]ShareLink, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	links := []ShareLink{}
+	err := repo.retry.do(ctx, repo.logger, "list share links", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, bson.M{"file_id": fileDocumentId}, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &links)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong listing share links", zap.Any("file_id", fileDocumentId), zap.Error(err))
+		return nil, err
+	}
+	return links, nil
+}
+
+// Revoke revokes a link of the file, revoking an already revoked link is a no-op
+func (repo *MongoShareLinkRepository) Revoke(ctx context.Context, linkId primitive.ObjectID, fileDocumentId primitive.ObjectID) error {
+	filter := bson.M{"_id": linkId, "file_id": fileDocumentId}
+	update := bson.M{"$min": bson.M{"revoked_at": time.Now()}}
+	var matched int64
+	err := repo.retry.do(ctx, repo.logger, "revoke share link", func(ctx context.Context) error {
+		result, err := repo.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		matched = result.MatchedCount
+		return nil
+	})
+	if err != nil {
+		repo.logger.Error("Failed to revoke share link", zap.Any("link_id", linkId), zap.Error(err))
+		return err
+	}
+	if matched == 0 {
+		return ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// ClaimDownload counts a download against the link's limit. The check and the increment are one update so
+// concurrent downloads cannot go past the limit, fails with ErrShareLinkExhausted once it is reached.
+// Not retried, a retry of an increment that went through would count the download twice.
+func (repo *MongoShareLinkRepository) ClaimDownload(ctx context.Context, linkId primitive.ObjectID) error {
+	filter := bson.M{
+		"_id": linkId,
+		"$or": bson.A{
+			bson.M{"max_downloads": bson.M{"$exists": false}},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$downloads", "$max_downloads"}}},
+		},
+	}
+	update := bson.M{"$inc": bson.M{"downloads": 1}}
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		repo.logger.Error("Failed to count share link download", zap.Any("link_id", linkId), zap.Error(err))
+		return wrapError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrShareLinkExhausted
+	}
+	return nil
+}
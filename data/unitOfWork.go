@@ -0,0 +1,43 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// MongoUnitOfWork groups repository writes into a single Mongo transaction.
+// Transactions require MongoDB to run as a replica set (a single node replica set is enough for development).
+type MongoUnitOfWork struct {
+	client *mongo.Client
+	logger *zap.Logger
+}
+
+func NewMongoUnitOfWork(db *MongoDB, logger *zap.Logger) *MongoUnitOfWork {
+	return &MongoUnitOfWork{
+		client: db.GetConnection(),
+		logger: logger,
+	}
+}
+
+// Do runs work inside a transaction. Repository calls made with the ctx handed to work join the transaction,
+// which is committed if work returns nil and aborted otherwise. Work may be retried on transient errors,
+// so it must not have side effects outside the database.
+func (uow *MongoUnitOfWork) Do(ctx context.Context, work func(ctx context.Context) error) error {
+	session, err := uow.client.StartSession()
+	if err != nil {
+		uow.logger.Error("Failed to start a database session", zap.Error(err))
+		return wrapError(err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, work(sessionCtx)
+	})
+	if err != nil {
+		uow.logger.Error("Transaction aborted", zap.Error(err))
+		return wrapError(err)
+	}
+	return nil
+}
@@ -5,10 +5,11 @@ import (
 	"errors"
 	"time"
 
-	"github.com/Hitesh-Nagothu/vault-service/utility"
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 )
 
@@ -17,86 +18,187 @@ type User struct {
 	Email          string               `bson:"email"`
 	LastAccessedOn time.Time            `bson:"last_accessed_on"`
 	Files          []primitive.ObjectID `bson:"files"`
+	Roles          []string             `bson:"roles,omitempty"`       //global roles, file roles live on the file
+	QuotaBytes     int64                `bson:"quota_bytes,omitempty"` //0 falls back to the configured default
 }
 
-type UserRepository struct {
+var ErrUserNotFound = apperror.NotFound("user_not_found", "user with given email does not exist").Wrap(ErrNotFound)
+
+type MongoUserRepository struct {
 	collection *mongo.Collection
 	logger     *zap.Logger
+	retry      retryPolicy
 }
 
-func NewUserRepository(db *MongoDB, logger *zap.Logger) *UserRepository {
-	return &UserRepository{
+func NewMongoUserRepository(db *MongoDB, logger *zap.Logger) *MongoUserRepository {
+	return &MongoUserRepository{
 		collection: db.GetDatabase().Collection("user"),
 		logger:     logger,
+		retry:      db.retry,
 	}
 }
 
-func (repo *UserRepository) Add(user *User) (User, error) {
-	// Insert the user document into the users collection
-	insertResult, err := repo.collection.InsertOne(context.Background(), user)
+// EnsureIndexes makes emails unique, so concurrent first sign-ins of a user fail with ErrDuplicate instead of
+// creating the user twice
+func (repo *MongoUserRepository) EnsureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)}
+	_, err := repo.collection.Indexes().CreateOne(ctx, index)
 	if err != nil {
-		repo.logger.Fatal("Something went wrong creating the user", zap.Error(err))
-		return User{}, nil
+		repo.logger.Error("Failed to create user indexes", zap.Error(err))
+		return wrapError(err)
+	}
+	return nil
+}
+
+// Add fails with ErrDuplicate if a user with the email already exists
+func (repo *MongoUserRepository) Add(ctx context.Context, user *User) (User, error) {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
 	}
-	repo.logger.Info("Created a new user successfully", zap.Any("objectId", insertResult.InsertedID))
-	insertedUser := User{}
-	err = repo.collection.FindOne(context.Background(), bson.M{"_id": insertResult.InsertedID}).Decode(&insertedUser)
+	err := repo.retry.do(ctx, repo.logger, "add user", func(ctx context.Context) error {
+		return insertOnce(ctx, repo.collection, user.ID, user)
+	})
 	if err != nil {
-		repo.logger.Error("Something went wrong getting user by object id", zap.Any("_id", insertResult.InsertedID))
-		return User{}, nil
+		repo.logger.Error("Something went wrong creating the user", zap.String("email", user.Email), zap.Error(err))
+		return User{}, err
 	}
-	return insertedUser, nil
+	repo.logger.Info("Created a new user successfully", zap.Any("objectId", user.ID))
+	return *user, nil
 }
 
-func (repo *UserRepository) Get(email string) (User, error) {
+// Get fails with ErrUserNotFound if no user has the email
+func (repo *MongoUserRepository) Get(ctx context.Context, email string) (User, error) {
+	var user User
+	err := repo.retry.do(ctx, repo.logger, "get user", func(ctx context.Context) error {
+		return repo.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		repo.logger.Error("Something went wrong getting user with email", zap.String("email", email), zap.Error(err))
+		return User{}, err
+	}
+	return user, nil
+}
 
-	// Define the filter to match the email field
-	filter := bson.M{"email": email}
+// Update merges the file ids into the user's files and records the access, in a single atomic update so concurrent
+// uploads of the same user do not lose each other's files
+func (repo *MongoUserRepository) Update(ctx context.Context, userDocumentId primitive.ObjectID, updateObject User) error {
+	files := updateObject.Files
+	if files == nil {
+		files = []primitive.ObjectID{}
+	}
+	update := bson.M{
+		"$set":      bson.M{"last_accessed_on": time.Now()},
+		"$addToSet": bson.M{"files": bson.M{"$each": files}}, //not overwriting but merging the file ids
+	}
 
-	// Query the collection for a matching document
-	var result User
-	err := repo.collection.FindOne(context.Background(), filter).Decode(&result)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			repo.logger.Error("Email ID not found", zap.String("email", email))
-			return User{}, nil //not propagating the error to the caller, to allow creating new if not found
-		} else {
-			repo.logger.Fatal("Something went wrong getting user with email", zap.String("email", email), zap.Error(err))
-			return User{}, err
+	var matched int64
+	err := repo.retry.do(ctx, repo.logger, "update user", func(ctx context.Context) error {
+		result, err := repo.collection.UpdateOne(ctx, bson.M{"_id": userDocumentId}, update)
+		if err != nil {
+			return err
 		}
+		matched = result.MatchedCount
+		return nil
+	})
+	if err != nil {
+		repo.logger.Error("Failed to update user with new info", zap.Any("user_id", userDocumentId), zap.Error(err))
+		return err
 	}
-
-	return result, nil
+	if matched == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
-func (repo *UserRepository) Update(userDocumentId primitive.ObjectID, updateObject User) error {
+// RemoveFile takes the file out of the file list of every user holding it
+func (repo *MongoUserRepository) RemoveFile(ctx context.Context, fileDocumentId primitive.ObjectID) error {
+	filter := bson.M{"files": fileDocumentId}
+	update := bson.M{"$pull": bson.M{"files": fileDocumentId}}
+	err := repo.retry.do(ctx, repo.logger, "remove file from users", func(ctx context.Context) error {
+		_, err := repo.collection.UpdateMany(ctx, filter, update)
+		return err
+	})
+	if err != nil {
+		repo.logger.Error("Failed to remove file from users", zap.Any("file_id", fileDocumentId), zap.Error(err))
+		return err
+	}
+	return nil
+}
 
-	filter := bson.M{"_id": userDocumentId}
+// GetByID fails with ErrUserNotFound if no user has the id, same as Get
+func (repo *MongoUserRepository) GetByID(ctx context.Context, userDocumentId primitive.ObjectID) (User, error) {
 	var user User
-	err := repo.collection.FindOne(context.Background(), filter).Decode(&user)
+	err := repo.retry.do(ctx, repo.logger, "get user by id", func(ctx context.Context) error {
+		return repo.collection.FindOne(ctx, bson.M{"_id": userDocumentId}).Decode(&user)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return User{}, ErrUserNotFound
+	}
 	if err != nil {
-		repo.logger.Error("Failed to find user with id", zap.Any("user_id", userDocumentId))
-		return errors.New("user with given document id does not exist")
+		repo.logger.Error("Something went wrong getting user by object id", zap.Any("user_id", userDocumentId), zap.Error(err))
+		return User{}, err
 	}
+	return user, nil
+}
 
-	newLastAccessedOnTime := time.Now()
-	newUserFiles := utility.UnionOfIds(user.Files, updateObject.Files) //not overwriting but merging the file ids
-
-	updatedUser := bson.M{
-		"$set": bson.M{
-			"LastAccessedOn": newLastAccessedOnTime,
-			"Files":          newUserFiles,
-		},
+// List returns a page of users ordered by id, starting after the given id
+func (repo *MongoUserRepository) List(ctx context.Context, afterId primitive.ObjectID, limit int64) ([]User, error) {
+	filter := bson.M{}
+	if !afterId.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterId}
 	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
 
-	_, updateErr := repo.collection.UpdateOne(context.Background(), filter, updatedUser)
+	users := []User{}
+	err := repo.retry.do(ctx, repo.logger, "list users", func(ctx context.Context) error {
+		cursor, err := repo.collection.Find(ctx, filter, findOptions)
+		if err != nil {
+			return err
+		}
+		return cursor.All(ctx, &users)
+	})
+	if err != nil {
+		repo.logger.Error("Something went wrong listing users", zap.Error(err))
+		return nil, err
+	}
+	return users, nil
+}
 
-	if updateErr != nil {
-		repo.logger.Error("Failed to update user with new info", zap.Error(updateErr), zap.Any("attempted_update", updatedUser))
-		return errors.New("failed to udpate user")
+func (repo *MongoUserRepository) SetQuota(ctx context.Context, email string, quotaBytes int64) error {
+	err := repo.updateByEmail(ctx, "set user quota", email, bson.M{"$set": bson.M{"quota_bytes": quotaBytes}})
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		repo.logger.Error("Failed to set user quota", zap.String("email", email), zap.Error(err))
 	}
+	return err
+}
 
-	repo.logger.Info("User update with new info", zap.Any("update_entry", updatedUser))
+func (repo *MongoUserRepository) AddRole(ctx context.Context, email string, role string) error {
+	err := repo.updateByEmail(ctx, "add user role", email, bson.M{"$addToSet": bson.M{"roles": role}})
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		repo.logger.Error("Failed to add user role", zap.String("email", email), zap.String("role", role), zap.Error(err))
+	}
+	return err
+}
 
+// updateByEmail applies an update that can run twice to the user with the email, fails with ErrUserNotFound if there is none
+func (repo *MongoUserRepository) updateByEmail(ctx context.Context, name string, email string, update bson.M) error {
+	var matched int64
+	err := repo.retry.do(ctx, repo.logger, name, func(ctx context.Context) error {
+		result, err := repo.collection.UpdateOne(ctx, bson.M{"email": email}, update)
+		if err != nil {
+			return err
+		}
+		matched = result.MatchedCount
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return ErrUserNotFound
+	}
 	return nil
 }
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// Admin serves the operations reserved to admins, one handler func per route:
+// ListUsers (?cursor=&limit=), InspectFile (any file by id, trash included), GetQuota (?email=),
+// SetQuota (?email=&bytes=), GetStorageStats, GetGCReport (the last run), RunGC (?dry_run=) and GetScrubReport
+// (the last pass)
+type Admin struct {
+	logger       *zap.Logger
+	adminService *service.AdminService
+}
+
+func NewAdmin(l *zap.Logger, as *service.AdminService) *Admin {
+	return &Admin{
+		logger:       l,
+		adminService: as,
+	}
+}
+
+func (handler *Admin) principal(w http.ResponseWriter, r *http.Request) (identity.Principal, bool) {
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+	}
+	return principal, ok
+}
+
+func (handler *Admin) ListUsers(w http.ResponseWriter, r *http.Request) {
+	principal, ok := handler.principal(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 0
+	if limitParam := r.URL.Query().Get("limit"); len(limitParam) > 0 {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 {
+			writeError(w, r, handler.logger, apperror.Invalid("invalid_limit", "Limit must be a positive number"))
+			return
+		}
+		limit = parsed
+	}
+
+	page, err := handler.adminService.ListUsers(r.Context(), principal, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, page)
+}
+
+func (handler *Admin) InspectFile(w http.ResponseWriter, r *http.Request) {
+	principal, ok := handler.principal(w, r)
+	if !ok {
+		return
+	}
+
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	inspection, err := handler.adminService.InspectFile(r.Context(), principal, fileId)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, inspection)
+}
+
+func (handler *Admin) GetQuota(w http.ResponseWriter, r *http.Request) {
+	principal, ok := handler.principal(w, r)
+	if !ok {
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	if len(email) == 0 {
+		writeError(w, r, handler.logger, apperror.Invalid("missing_email", "User email is required"))
+		return
+	}
+
+	quota, err := handler.adminService.GetQuota(r.Context(), principal, email)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, quota)
+}
+
+func (handler *Admin) SetQuota(w http.ResponseWriter, r *http.Request) {
+	principal, ok := handler.principal(w, r)
+	if !ok {
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	if len(email) == 0 {
+		writeError(w, r, handler.logger, apperror.Invalid("missing_email", "User email is required"))
+		return
+	}
+	quotaBytes, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+	if err != nil || quotaBytes < 0 {
+		writeError(w, r, handler.logger, apperror.Invalid("invalid_quota", "Bytes must be zero, for the default quota, or a positive number"))
+		return
+	}
+
+	quota, err := handler.adminService.SetQuota(r.Context(), principal, email, quotaBytes)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, quota)
+}
+
+func (handler *Admin) GetStorageStats(w http.ResponseWriter, r *http.Request) {
+	principal, ok := handler.principal(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := handler.adminService.StorageStats(r.Context(), principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, stats)
+}
+
+func (handler *Admin) GetGCReport(w http.ResponseWriter, r *http.Request) {
+	principal, ok := handler.principal(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := handler.adminService.LastGCReport(r.Context(), principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, report)
+}
+
+func (handler *Admin) RunGC(w http.ResponseWriter, r *http.Request) {
+	principal, ok := handler.principal(w, r)
+	if !ok {
+		return
+	}
+
+	dryRun := false
+	if dryRunParam := r.URL.Query().Get("dry_run"); len(dryRunParam) > 0 {
+		parsed, err := strconv.ParseBool(dryRunParam)
+		if err != nil {
+			writeError(w, r, handler.logger, apperror.Invalid("invalid_dry_run", "Dry run must be true or false"))
+			return
+		}
+		dryRun = parsed
+	}
+
+	report, err := handler.adminService.RunGC(r.Context(), principal, dryRun)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, report)
+}
+
+func (handler *Admin) GetScrubReport(w http.ResponseWriter, r *http.Request) {
+	principal, ok := handler.principal(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := handler.adminService.LastScrubReport(r.Context(), principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, report)
+}
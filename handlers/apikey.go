@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// APIKey serves the caller's API keys. POST creates one from a {"name": ..., "scopes": [...]} body, GET lists them
+// and DELETE ?id= revokes one. Scopes are read, write, delete, share and admin, a key without scopes is unrestricted.
+// A key limited to scopes cannot manage keys.
+type APIKey struct {
+	logger        *zap.Logger
+	apiKeyService *service.APIKeyService
+}
+
+func NewAPIKey(l *zap.Logger, aks *service.APIKeyService) *APIKey {
+	return &APIKey{
+		logger:        l,
+		apiKeyService: aks,
+	}
+}
+
+func (handler *APIKey) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		handler.listKeys(w, r, principal)
+	case http.MethodPost:
+		handler.createKey(w, r, principal)
+	case http.MethodDelete:
+		handler.revokeKey(w, r, principal)
+	default:
+		handler.logger.Error("Received bad api key request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+}
+
+func (handler *APIKey) createKey(w http.ResponseWriter, r *http.Request, principal identity.Principal) {
+	var body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Name) == 0 {
+		writeError(w, r, handler.logger, errInvalidBody.WithMessage("A JSON body with a key name is required"))
+		return
+	}
+
+	created, err := handler.apiKeyService.CreateKey(r.Context(), principal, body.Name, body.Scopes)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, created)
+}
+
+func (handler *APIKey) listKeys(w http.ResponseWriter, r *http.Request, principal identity.Principal) {
+	keys, err := handler.apiKeyService.ListKeys(r.Context(), principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, keys)
+}
+
+func (handler *APIKey) revokeKey(w http.ResponseWriter, r *http.Request, principal identity.Principal) {
+	keyId := idParam(r)
+	if len(keyId) == 0 {
+		writeError(w, r, handler.logger, apperror.Invalid("missing_key_id", "Key id is required"))
+		return
+	}
+
+	err := handler.apiKeyService.RevokeKey(r.Context(), principal, keyId)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	fmt.Fprint(w, "Api key revoked")
+}
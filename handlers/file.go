@@ -1,17 +1,35 @@
 package handlers
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
 	"github.com/Hitesh-Nagothu/vault-service/service"
 	"go.uber.org/zap"
 )
 
+var (
+	errExpectedMultipart = apperror.New(apperror.KindUnsupportedType, "expected_multipart", "Expected a multipart/form-data request")
+	errMissingFilePart   = apperror.Invalid("missing_file_field", "Failed to retrieve file from request")
+	errInvalidDigest     = apperror.Invalid("invalid_digest", "Digest and Content-MD5 headers of the file field must hold base64 encoded SHA-256 or MD5 digests")
+)
+
 type File struct {
 	logger      *zap.Logger
 	fileService *service.FileService
-	ipfsService *service.IPFSService
 }
 
 func NewFile(l *zap.Logger, fs *service.FileService) *File {
@@ -34,7 +52,7 @@ func (handler *File) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		handler.deleteFile(w, r)
 	default:
 		handler.logger.Error("Received bad POST request", zap.String("HTTP Method", r.Method))
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, handler.logger, errMethodNotAllowed)
 		return
 	}
 
@@ -43,42 +61,281 @@ func (handler *File) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (handler *File) uploadFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		handler.logger.Error("Method not allowed")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, handler.logger, errMethodNotAllowed)
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("file")
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Cannot process the file")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	//reading parts as they arrive instead of r.FormFile, which buffers the whole upload before handing it over
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		handler.logger.Error("Failed to read multipart request", zap.Error(err))
+		writeError(w, r, handler.logger, errExpectedMultipart)
+		return
+	}
+
+	file, err := nextFilePart(multipartReader)
 	if err != nil {
 		handler.logger.Error("Failed to retrieve file from request", zap.Error(err))
-		http.Error(w, "Failed to retrieve file from request", http.StatusBadRequest)
+		writeError(w, r, handler.logger, errMissingFilePart)
 		return
 	}
 	defer file.Close()
 
-	userEmailFromContext, _ := r.Context().Value("email").(string)
-	if len(userEmailFromContext) == 0 {
-		handler.logger.Error("No user email found. Cannot process the file")
-		http.Error(w, "No user email found. Cannot process the file", http.StatusBadRequest)
+	expected, err := expectedDigests(http.Header(file.Header))
+	if err != nil {
+		writeError(w, r, handler.logger, err)
 		return
 	}
 
-	uploadFileErr := handler.fileService.CreateFile(file, fileHeader, userEmailFromContext)
+	uploadFileErr := handler.fileService.CreateFile(r.Context(), file, file.FileName(), principal, expected)
 	if uploadFileErr != nil {
-		http.Error(w, "Failed to upload file "+uploadFileErr.Error(), http.StatusBadRequest)
+		writeError(w, r, handler.logger, uploadFileErr)
 		return
 	}
 
 	fmt.Fprint(w, "File upload complete")
 }
 
+// nextFilePart skips ahead to the "file" form field, other fields sent before it are ignored
+func nextFilePart(multipartReader *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("no file field found in form")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" && len(part.FileName()) > 0 {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
 func (handler *File) getFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		handler.logger.Error("Method not allowed")
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
 
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Cannot fetch the file")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	file, err := handler.fileService.GetFile(r.Context(), fileId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	//version defaults to the current one
+	versionNumber := 0
+	if versionParam := r.URL.Query().Get("version"); len(versionParam) > 0 {
+		versionNumber, err = strconv.Atoi(versionParam)
+		if err != nil || versionNumber < 1 {
+			writeError(w, r, handler.logger, apperror.Invalid("invalid_version", "Version must be a positive number"))
+			return
+		}
+	}
+
+	version, err := handler.fileService.GetVersion(file, versionNumber)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	content, err := handler.fileService.OpenVersion(r.Context(), version)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+	defer content.Close()
+
+	serveContent(w, handler.logger, handler.fileService, file, version, content)
 }
 
+// serveContent writes the download headers of the file version and streams its content as an attachment
+func serveContent(w http.ResponseWriter, logger *zap.Logger, fileService *service.FileService, file data.File, version data.FileVersion, content io.Reader) {
+	w.Header().Set("Content-Type", fileService.GetContentType(file.Type))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	if version.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(version.Size, 10))
+	}
+	setDigestHeaders(w, version)
+	w.WriteHeader(http.StatusOK)
+
+	//headers are already sent at this point, a failure midway can only be logged
+	_, streamErr := io.Copy(w, content)
+	if streamErr != nil {
+		logger.Error("Failed to stream file to client", zap.String("file_id", file.ID.Hex()), zap.Error(streamErr))
+	}
+}
+
+// setDigestHeaders sets the ETag and the Digest (RFC 3230) of the whole version's content, also on range responses.
+// Files uploaded before digests were recorded get neither.
+func setDigestHeaders(w http.ResponseWriter, version data.FileVersion) {
+	sha256Sum, err := hex.DecodeString(version.Hash)
+	if err != nil || len(sha256Sum) == 0 {
+		return
+	}
+	w.Header().Set("ETag", `"`+version.Hash+`"`)
+
+	digest := "sha-256=" + base64.StdEncoding.EncodeToString(sha256Sum)
+	if md5Sum, err := hex.DecodeString(version.MD5); err == nil && len(md5Sum) > 0 {
+		digest += ",md5=" + base64.StdEncoding.EncodeToString(md5Sum)
+	}
+	w.Header().Set("Digest", digest)
+}
+
+// expectedDigests reads the digests a client sent with an upload from the Digest (RFC 3230) and Content-MD5 headers
+// of the multipart file field, digests of other algorithms are ignored. The request's own headers describe the whole
+// multipart body rather than the file, so they are not read.
+func expectedDigests(header http.Header) (service.Digests, error) {
+	digests := service.Digests{}
+	for _, value := range header.Values("Digest") {
+		for _, instance := range strings.Split(value, ",") {
+			algorithm, encoded, found := strings.Cut(strings.TrimSpace(instance), "=")
+			if !found {
+				return service.Digests{}, errInvalidDigest
+			}
+
+			var err error
+			switch strings.ToLower(algorithm) {
+			case "sha-256":
+				digests.SHA256, err = decodeDigest(encoded, sha256.Size)
+			case "md5":
+				digests.MD5, err = decodeDigest(encoded, md5.Size)
+			}
+			if err != nil {
+				return service.Digests{}, err
+			}
+		}
+	}
+
+	if contentMD5 := header.Get("Content-MD5"); len(contentMD5) > 0 {
+		md5Digest, err := decodeDigest(contentMD5, md5.Size)
+		if err != nil {
+			return service.Digests{}, err
+		}
+		digests.MD5 = md5Digest
+	}
+	return digests, nil
+}
+
+// decodeDigest turns a base64 digest of size bytes into hex. Hex is accepted too, some clients send that instead.
+func decodeDigest(encoded string, size int) (string, error) {
+	encoded = strings.TrimSpace(encoded)
+	if len(encoded) == hex.EncodedLen(size) {
+		if sum, err := hex.DecodeString(encoded); err == nil {
+			return hex.EncodeToString(sum), nil
+		}
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sum) != size {
+		return "", errInvalidDigest
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// updateFile uploads the multipart "file" field as a new version of the file with the given id
 func (handler *File) updateFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		handler.logger.Error("Method not allowed")
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Cannot process the file")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		handler.logger.Error("Failed to read multipart request", zap.Error(err))
+		writeError(w, r, handler.logger, errExpectedMultipart)
+		return
+	}
+
+	file, err := nextFilePart(multipartReader)
+	if err != nil {
+		handler.logger.Error("Failed to retrieve file from request", zap.Error(err))
+		writeError(w, r, handler.logger, errMissingFilePart)
+		return
+	}
+	defer file.Close()
 
+	expected, err := expectedDigests(http.Header(file.Header))
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	version, err := handler.fileService.UpdateFile(r.Context(), fileId, file, principal, expected)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	fmt.Fprintf(w, "File updated to version %d", version.Number)
 }
 
+// deleteFile moves the file to the trash, see the Trash handler to restore or purge it
 func (handler *File) deleteFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		handler.logger.Error("Method not allowed")
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Cannot delete the file")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	err := handler.fileService.DeleteFile(r.Context(), fileId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
 
+	fmt.Fprint(w, "File moved to trash")
 }
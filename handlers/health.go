@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// Health serves the unauthenticated probes. Liveness (/healthz) only tells the process is serving requests,
+// readiness (/readyz) checks every dependency and answers 503 while any of them is down.
+type Health struct {
+	logger        *zap.Logger
+	healthService *service.HealthService
+}
+
+func NewHealth(l *zap.Logger, hs *service.HealthService) *Health {
+	return &Health{
+		logger:        l,
+		healthService: hs,
+	}
+}
+
+func (handler *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, handler.logger, map[string]string{"status": service.StatusUp})
+}
+
+func (handler *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	report := handler.healthService.Ready(r.Context())
+
+	status := http.StatusOK
+	if report.Status != service.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		handler.logger.Error("Failed to write readiness report", zap.Error(err))
+	}
+}
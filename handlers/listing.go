@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// FileList serves GET /files, a page of the caller's files.
+// Query parameters: cursor, limit, sort (name, size, created, updated), order (asc, desc) and type.
+type FileList struct {
+	logger      *zap.Logger
+	fileService *service.FileService
+}
+
+func NewFileList(l *zap.Logger, fs *service.FileService) *FileList {
+	return &FileList{
+		logger:      l,
+		fileService: fs,
+	}
+}
+
+func (handler *FileList) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		handler.logger.Error("Received bad file list request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	query := r.URL.Query()
+	request := service.ListFilesRequest{
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+		Order:  query.Get("order"),
+		Type:   query.Get("type"),
+	}
+	if limitParam := query.Get("limit"); len(limitParam) > 0 {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			writeError(w, r, handler.logger, apperror.Invalid("invalid_limit", "Limit must be a positive number"))
+			return
+		}
+		request.Limit = limit
+	}
+
+	page, err := handler.fileService.ListFiles(r.Context(), principal, request)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, page)
+}
+
+// FileMetadata serves GET /file/metadata?id=, the metadata of a single file without its content
+type FileMetadata struct {
+	logger      *zap.Logger
+	fileService *service.FileService
+}
+
+func NewFileMetadata(l *zap.Logger, fs *service.FileService) *FileMetadata {
+	return &FileMetadata{
+		logger:      l,
+		fileService: fs,
+	}
+}
+
+func (handler *FileMetadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		handler.logger.Error("Received bad file metadata request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	metadata, err := handler.fileService.GetFileMetadata(r.Context(), fileId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, metadata)
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/auth"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// Presign serves POST /file/presign?id=&op=download|upload, with optional expires_in (e.g. 10m) and,
+// for downloads, range=start-end. It returns a URL that works without the bearer token until it expires.
+// Upload URLs replace the content of an existing file with a new version, a file is created through POST /files
+// first and only then can be uploaded to directly.
+type Presign struct {
+	logger         *zap.Logger
+	presignService *service.PresignService
+}
+
+func NewPresign(l *zap.Logger, ps *service.PresignService) *Presign {
+	return &Presign{
+		logger:         l,
+		presignService: ps,
+	}
+}
+
+func (handler *Presign) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		handler.logger.Error("Received bad presign request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	var expiresIn time.Duration
+	if expiresParam := query.Get("expires_in"); len(expiresParam) > 0 {
+		parsed, err := time.ParseDuration(expiresParam)
+		if err != nil || parsed <= 0 {
+			writeError(w, r, handler.logger, apperror.Invalid("invalid_expiry", "expires_in must be a positive duration such as 10m"))
+			return
+		}
+		expiresIn = parsed
+	}
+	var byteRange *auth.ByteRange
+	if rangeParam := query.Get("range"); len(rangeParam) > 0 {
+		parsed, err := auth.ParseByteRange(rangeParam)
+		if err != nil {
+			writeError(w, r, handler.logger, service.ErrInvalidPresignRequest.WithMessage(err.Error()))
+			return
+		}
+		byteRange = &parsed
+	}
+
+	presigned, err := handler.presignService.PresignURL(r.Context(), fileId, query.Get("op"), expiresIn, byteRange, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, presigned)
+}
+
+// SignedDownload serves GET /signed/download, the file of a URL signed for download. The signature is checked
+// by the signature middleware, a signed byte range is answered with 206 Partial Content.
+type SignedDownload struct {
+	logger      *zap.Logger
+	fileService *service.FileService
+}
+
+func NewSignedDownload(l *zap.Logger, fs *service.FileService) *SignedDownload {
+	return &SignedDownload{
+		logger:      l,
+		fileService: fs,
+	}
+}
+
+func (handler *SignedDownload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	grant, granted := auth.SignedURLFromContext(r.Context())
+	principal, ok := identity.FromContext(r.Context())
+	if !granted || !ok {
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	file, err := handler.fileService.GetFile(r.Context(), grant.FileID, principal)
+	if err != nil {
+		//the url outlived the access of its signer, not telling the holder the file still exists
+		if errors.Is(err, service.ErrForbidden) {
+			err = service.ErrFileNotFound
+		}
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	version, err := handler.fileService.GetVersion(file, 0)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	if grant.Range == nil {
+		content, err := handler.fileService.OpenVersion(r.Context(), version)
+		if err != nil {
+			writeError(w, r, handler.logger, err)
+			return
+		}
+		defer content.Close()
+
+		serveContent(w, handler.logger, handler.fileService, file, version, content)
+		return
+	}
+
+	//the file may have changed since the url was signed, the range is cut to the current size
+	if grant.Range.Start >= version.Size {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", version.Size))
+		writeError(w, r, handler.logger, apperror.New(apperror.KindRangeNotSatisfied, "range_not_satisfiable", "Signed range is outside the file"))
+		return
+	}
+	byteRange := *grant.Range
+	if byteRange.End >= version.Size {
+		byteRange.End = version.Size - 1
+	}
+
+	content, err := handler.fileService.OpenVersionRange(r.Context(), version, byteRange.Start, byteRange.Length())
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", handler.fileService.GetContentType(file.Type))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", byteRange.Start, byteRange.End, version.Size))
+	w.Header().Set("Content-Length", strconv.FormatInt(byteRange.Length(), 10))
+	setDigestHeaders(w, version)
+	w.WriteHeader(http.StatusPartialContent)
+
+	_, streamErr := io.Copy(w, content)
+	if streamErr != nil {
+		handler.logger.Error("Failed to stream file range to client", zap.String("file_id", grant.FileID), zap.Error(streamErr))
+	}
+}
+
+// SignedUpload serves PUT /signed/upload, the raw request body becomes a new version of the file of a URL
+// signed for upload. Only existing files can be signed for upload, see Presign.
+type SignedUpload struct {
+	logger      *zap.Logger
+	fileService *service.FileService
+}
+
+func NewSignedUpload(l *zap.Logger, fs *service.FileService) *SignedUpload {
+	return &SignedUpload{
+		logger:      l,
+		fileService: fs,
+	}
+}
+
+func (handler *SignedUpload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	grant, granted := auth.SignedURLFromContext(r.Context())
+	principal, ok := identity.FromContext(r.Context())
+	if !granted || !ok {
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	expected, err := expectedDigests(r.Header)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	version, err := handler.fileService.UpdateFile(r.Context(), grant.FileID, r.Body, principal, expected)
+	if err != nil {
+		if errors.Is(err, service.ErrForbidden) {
+			err = service.ErrFileNotFound
+		}
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	fmt.Fprintf(w, "File updated to version %d", version.Number)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/middlewares"
+	"go.uber.org/zap"
+)
+
+var (
+	errMethodNotAllowed = apperror.New(apperror.KindMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	errNotImplemented   = apperror.New(apperror.KindNotImplemented, "not_implemented", "Method not implemented")
+	errUnidentified     = apperror.New(apperror.KindUnauthorized, "unidentified_user", "Something went wrong. Failed to identify user")
+	errMissingFileID    = apperror.Invalid("missing_file_id", "File id is required")
+	errInvalidBody      = apperror.Invalid("invalid_body", "Invalid JSON body")
+)
+
+// writeError writes the error as a problem+json response. Server errors are logged with their cause, which is
+// never sent to the client.
+func writeError(w http.ResponseWriter, r *http.Request, logger *zap.Logger, err error) {
+	appErr := apperror.From(err)
+	if appErr.Kind.Status() >= http.StatusInternalServerError {
+		logger.Error("Request failed", zap.String("path", r.URL.Path), zap.String("code", appErr.Code), zap.Error(err))
+	}
+	apperror.Write(w, r, appErr)
+}
+
+// writeJSON encodes the body as the JSON response
+func writeJSON(w http.ResponseWriter, logger *zap.Logger, body interface{}) {
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+		apperror.Write(w, nil, apperror.Internal("Failed to encode response", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(bodyJSON)
+	if err != nil {
+		logger.Error("Failed to write response", zap.Error(err))
+	}
+}
+
+// idParam returns the {id} path parameter of routes like /files/{id}/versions, falling back to the id query
+// parameter of the older routes like /file/versions?id=
+func idParam(r *http.Request) string {
+	if id := middlewares.PathParam(r, "id"); len(id) > 0 {
+		return id
+	}
+	return r.URL.Query().Get("id")
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// FileShares serves /file/shares?id=, the users a file is shared with. POST shares the file from an
+// {"email": ..., "permission": "read" | "write"} body, GET lists the shares and DELETE &email= revokes one.
+type FileShares struct {
+	logger      *zap.Logger
+	fileService *service.FileService
+}
+
+func NewFileShares(l *zap.Logger, fs *service.FileService) *FileShares {
+	return &FileShares{
+		logger:      l,
+		fileService: fs,
+	}
+}
+
+func (handler *FileShares) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		handler.listShares(w, r, fileId, principal)
+	case http.MethodPost:
+		handler.shareFile(w, r, fileId, principal)
+	case http.MethodDelete:
+		handler.revokeShare(w, r, fileId, principal)
+	default:
+		handler.logger.Error("Received bad file shares request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+}
+
+func (handler *FileShares) shareFile(w http.ResponseWriter, r *http.Request, fileId string, principal identity.Principal) {
+	var body struct {
+		Email      string `json:"email"`
+		Permission string `json:"permission"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, r, handler.logger, errInvalidBody.WithMessage("A JSON body with an email and permission is required"))
+		return
+	}
+
+	share, err := handler.fileService.ShareFile(r.Context(), fileId, body.Email, body.Permission, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, share)
+}
+
+func (handler *FileShares) listShares(w http.ResponseWriter, r *http.Request, fileId string, principal identity.Principal) {
+	shares, err := handler.fileService.ListShares(r.Context(), fileId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, shares)
+}
+
+func (handler *FileShares) revokeShare(w http.ResponseWriter, r *http.Request, fileId string, principal identity.Principal) {
+	email := r.URL.Query().Get("email")
+	if len(email) == 0 {
+		writeError(w, r, handler.logger, apperror.Invalid("missing_email", "Email is required"))
+		return
+	}
+
+	err := handler.fileService.RevokeShare(r.Context(), fileId, email, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	fmt.Fprint(w, "Share revoked")
+}
+
+// SharedFiles serves GET /shared, the files other users shared with the caller
+type SharedFiles struct {
+	logger      *zap.Logger
+	fileService *service.FileService
+}
+
+func NewSharedFiles(l *zap.Logger, fs *service.FileService) *SharedFiles {
+	return &SharedFiles{
+		logger:      l,
+		fileService: fs,
+	}
+}
+
+func (handler *SharedFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		handler.logger.Error("Received bad shared files request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	files, err := handler.fileService.ListSharedWithMe(r.Context(), principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, files)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/middlewares"
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// ShareLinks serves /file/links?id=, the public links to a file. POST creates one from a
+// {"expires_in": "24h" | "expires_at": RFC 3339, "password": ..., "max_downloads": n} body, every field optional,
+// GET lists them and DELETE &link= revokes one.
+type ShareLinks struct {
+	logger           *zap.Logger
+	shareLinkService *service.ShareLinkService
+}
+
+func NewShareLinks(l *zap.Logger, sls *service.ShareLinkService) *ShareLinks {
+	return &ShareLinks{
+		logger:           l,
+		shareLinkService: sls,
+	}
+}
+
+func (handler *ShareLinks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		handler.listLinks(w, r, fileId, principal)
+	case http.MethodPost:
+		handler.createLink(w, r, fileId, principal)
+	case http.MethodDelete:
+		handler.revokeLink(w, r, fileId, principal)
+	default:
+		handler.logger.Error("Received bad share link request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+}
+
+func (handler *ShareLinks) createLink(w http.ResponseWriter, r *http.Request, fileId string, principal identity.Principal) {
+	var body struct {
+		ExpiresIn    string     `json:"expires_in"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+		Password     string     `json:"password"`
+		MaxDownloads int        `json:"max_downloads"`
+	}
+	//an empty body creates a link without restrictions
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeError(w, r, handler.logger, errInvalidBody)
+		return
+	}
+
+	linkOptions := service.ShareLinkOptions{
+		ExpiresAt:    body.ExpiresAt,
+		Password:     body.Password,
+		MaxDownloads: body.MaxDownloads,
+	}
+	if len(body.ExpiresIn) > 0 {
+		expiresIn, err := time.ParseDuration(body.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			writeError(w, r, handler.logger, apperror.Invalid("invalid_expiry", "expires_in must be a positive duration such as 24h"))
+			return
+		}
+		expiresAt := time.Now().Add(expiresIn)
+		linkOptions.ExpiresAt = &expiresAt
+	}
+
+	created, err := handler.shareLinkService.CreateLink(r.Context(), fileId, principal, linkOptions)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, created)
+}
+
+func (handler *ShareLinks) listLinks(w http.ResponseWriter, r *http.Request, fileId string, principal identity.Principal) {
+	links, err := handler.shareLinkService.ListLinks(r.Context(), fileId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, links)
+}
+
+func (handler *ShareLinks) revokeLink(w http.ResponseWriter, r *http.Request, fileId string, principal identity.Principal) {
+	linkId := r.URL.Query().Get("link")
+	if len(linkId) == 0 {
+		writeError(w, r, handler.logger, apperror.Invalid("missing_link_id", "Link id is required"))
+		return
+	}
+
+	err := handler.shareLinkService.RevokeLink(r.Context(), fileId, linkId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	fmt.Fprint(w, "Share link revoked")
+}
+
+// PublicShare serves GET /s/{token} without authentication. The password of a protected link is taken from
+// basic auth, any username, or the X-Share-Password header.
+type PublicShare struct {
+	logger           *zap.Logger
+	shareLinkService *service.ShareLinkService
+	fileService      *service.FileService
+}
+
+func NewPublicShare(l *zap.Logger, sls *service.ShareLinkService, fs *service.FileService) *PublicShare {
+	return &PublicShare{
+		logger:           l,
+		shareLinkService: sls,
+		fileService:      fs,
+	}
+}
+
+func (handler *PublicShare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		handler.logger.Error("Received bad public share request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+
+	token := middlewares.PathParam(r, "token")
+	if len(token) == 0 {
+		writeError(w, r, handler.logger, service.ErrShareLinkNotFound)
+		return
+	}
+
+	password := r.Header.Get("X-Share-Password")
+	if _, basicPassword, ok := r.BasicAuth(); ok {
+		password = basicPassword
+	}
+
+	file, content, err := handler.shareLinkService.OpenLink(r.Context(), token, password)
+	if err != nil {
+		if errors.Is(err, service.ErrShareLinkPassword) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="shared file"`)
+		}
+		writeError(w, r, handler.logger, err)
+		return
+	}
+	defer content.Close()
+
+	version, err := handler.fileService.GetVersion(file, 0)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	serveContent(w, handler.logger, handler.fileService, file, version, content)
+}
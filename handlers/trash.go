@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// Trash serves the caller's trash. GET lists it, POST ?id= restores a file and DELETE ?id= purges it for good.
+type Trash struct {
+	logger      *zap.Logger
+	fileService *service.FileService
+}
+
+func NewTrash(l *zap.Logger, fs *service.FileService) *Trash {
+	return &Trash{
+		logger:      l,
+		fileService: fs,
+	}
+}
+
+func (handler *Trash) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handler.listTrash(w, r)
+	case http.MethodPost:
+		handler.restoreFile(w, r)
+	case http.MethodDelete:
+		handler.purgeFile(w, r)
+	default:
+		handler.logger.Error("Received bad trash request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+}
+
+func (handler *Trash) listTrash(w http.ResponseWriter, r *http.Request) {
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	files, err := handler.fileService.ListTrash(r.Context(), principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, files)
+}
+
+func (handler *Trash) restoreFile(w http.ResponseWriter, r *http.Request) {
+	fileId, principal, ok := handler.readTrashRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := handler.fileService.RestoreFile(r.Context(), fileId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	fmt.Fprint(w, "File restored")
+}
+
+func (handler *Trash) purgeFile(w http.ResponseWriter, r *http.Request) {
+	fileId, principal, ok := handler.readTrashRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := handler.fileService.PurgeFile(r.Context(), fileId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	fmt.Fprint(w, "File deleted permanently")
+}
+
+func (handler *Trash) readTrashRequest(w http.ResponseWriter, r *http.Request) (string, identity.Principal, bool) {
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return "", identity.Principal{}, false
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return "", identity.Principal{}, false
+	}
+
+	return fileId, principal, true
+}
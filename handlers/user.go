@@ -1,11 +1,10 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 
+	"github.com/Hitesh-Nagothu/vault-service/identity"
 	"github.com/Hitesh-Nagothu/vault-service/service"
-	"github.com/Hitesh-Nagothu/vault-service/utility"
 	"go.uber.org/zap"
 )
 
@@ -33,7 +32,7 @@ func (handler *User) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		handler.deletUser(w, r)
 	default:
 		handler.logger.Error("Received bad POST request", zap.String("HTTP Method", r.Method))
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, handler.logger, errMethodNotAllowed)
 		return
 	}
 }
@@ -41,94 +40,56 @@ func (handler *User) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (handler *User) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		handler.logger.Error("Method not allowed")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, handler.logger, errMethodNotAllowed)
 		return
 	}
 
-	userEmailFromContext, _ := r.Context().Value("email").(string)
-	if len(userEmailFromContext) == 0 {
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
 		handler.logger.Error("No user email found. Failed authentication")
-		http.Error(w, "Something went wrong. Failed to identify user", http.StatusBadRequest)
+		writeError(w, r, handler.logger, errUnidentified)
 		return
 	}
 
-	//create the user
-	user, err := handler.userService.CreateUser(userEmailFromContext)
+	//authentication already creates users on first sight, so this only fails on a database error
+	user, err := handler.userService.GetOrCreateUser(r.Context(), principal.Email)
 	if err != nil {
-		handler.logger.Error("Failed to create a new user", zap.String("email", userEmailFromContext), zap.Error(err))
-		http.Error(w, "Something went wrong. Try again", http.StatusInternalServerError)
+		handler.logger.Error("Failed to create a new user", zap.String("email", principal.Email), zap.Error(err))
+		writeError(w, r, handler.logger, err)
 		return
 	}
 
-	// Convert User object to JSON
-	userJSON, err := json.Marshal(user)
-	if err != nil {
-		handler.logger.Error("Failed to encode user data", zap.String("email", user.Email))
-		http.Error(w, "Failed to encode user data", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(userJSON)
-	if err != nil {
-		http.Error(w, "Failed to write response", http.StatusInternalServerError)
-		return
-	}
+	writeJSON(w, handler.logger, user)
 }
 
 func (handler *User) GetUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		handler.logger.Error("Method not allowed")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, handler.logger, errMethodNotAllowed)
 		return
 	}
 
-	userEmailFromContext, _ := r.Context().Value("email").(string)
-	if len(userEmailFromContext) == 0 {
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
 		handler.logger.Error("No user email found. Failed authentication")
-		http.Error(w, "Something went wrong. Failed to identify user", http.StatusBadRequest)
-		return
-	}
-
-	user, err := handler.userService.GetUser(userEmailFromContext)
-	if err != nil {
-		handler.logger.Error("User not found", zap.String("email", userEmailFromContext))
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeError(w, r, handler.logger, errUnidentified)
 		return
 	}
 
-	if utility.IsStructEmpty(user) {
-		//empty user, create one
-		createdUser, err := handler.userService.CreateUser(userEmailFromContext)
-		user = createdUser
-		if err != nil {
-			http.Error(w, "Somethign went wrong", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// Convert User object to JSON
-	userJSON, err := json.Marshal(user)
+	user, err := handler.userService.GetOrCreateUser(r.Context(), principal.Email)
 	if err != nil {
-		handler.logger.Error("Failed to encode user data", zap.String("email", user.Email))
-		http.Error(w, "Failed to encode user data", http.StatusInternalServerError)
+		handler.logger.Error("Failed to get user", zap.String("email", principal.Email), zap.Error(err))
+		writeError(w, r, handler.logger, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(userJSON)
-	if err != nil {
-		http.Error(w, "Failed to write response", http.StatusInternalServerError)
-		return
-	}
+	writeJSON(w, handler.logger, user)
 }
 
 func (handler *User) updateUser(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "Method not implementeed", http.StatusNotImplemented)
+	writeError(w, r, handler.logger, errNotImplemented)
 }
 
 func (handler *User) deletUser(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "Method not implementeed", http.StatusNotImplemented)
+	writeError(w, r, handler.logger, errNotImplemented)
 }
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/service"
+	"go.uber.org/zap"
+)
+
+// FileVersions serves the version history of a file. GET ?id= lists it, POST ?id=&version= rolls the file back.
+// Downloading a specific version is GET /file?id=&version=.
+type FileVersions struct {
+	logger      *zap.Logger
+	fileService *service.FileService
+}
+
+func NewFileVersions(l *zap.Logger, fs *service.FileService) *FileVersions {
+	return &FileVersions{
+		logger:      l,
+		fileService: fs,
+	}
+}
+
+func (handler *FileVersions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handler.listVersions(w, r)
+	case http.MethodPost:
+		handler.rollback(w, r)
+	default:
+		handler.logger.Error("Received bad file versions request", zap.String("HTTP Method", r.Method))
+		writeError(w, r, handler.logger, errMethodNotAllowed)
+		return
+	}
+}
+
+func (handler *FileVersions) listVersions(w http.ResponseWriter, r *http.Request) {
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	versions, err := handler.fileService.ListVersions(r.Context(), fileId, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, versions)
+}
+
+func (handler *FileVersions) rollback(w http.ResponseWriter, r *http.Request) {
+	fileId := idParam(r)
+	if len(fileId) == 0 {
+		handler.logger.Error("No file id found in request")
+		writeError(w, r, handler.logger, errMissingFileID)
+		return
+	}
+
+	versionNumber, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || versionNumber < 1 {
+		writeError(w, r, handler.logger, apperror.Invalid("invalid_version", "Version must be a positive number"))
+		return
+	}
+
+	principal, ok := identity.FromContext(r.Context())
+	if !ok {
+		handler.logger.Error("No user email found. Failed authentication")
+		writeError(w, r, handler.logger, errUnidentified)
+		return
+	}
+
+	version, err := handler.fileService.RollbackFile(r.Context(), fileId, versionNumber, principal)
+	if err != nil {
+		writeError(w, r, handler.logger, err)
+		return
+	}
+
+	writeJSON(w, handler.logger, version)
+}
@@ -0,0 +1,56 @@
+package identity
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID     primitive.ObjectID
+	Email      string
+	AuthMethod string
+	Scopes     []string //api keys only, empty means unrestricted
+	Tenant     string
+	Roles      []string //global roles of the user, e.g. admin
+}
+
+// HasScope reports whether the principal may act within the scope
+func (p Principal) HasScope(scope string) bool {
+	if len(p.Scopes) == 0 {
+		return true
+	}
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the principal holds the global role
+func (p Principal) HasRole(role string) bool {
+	for _, granted := range p.Roles {
+		if granted == role {
+			return true
+		}
+	}
+	return false
+}
+
+// contextKey is unexported so nothing outside this package can read or overwrite the principal by key
+type contextKey struct{}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal of the request, ok is false for unauthenticated requests
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(Principal)
+	if !ok || len(principal.Email) == 0 {
+		return Principal{}, false
+	}
+	return principal, true
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/Hitesh-Nagothu/vault-service/auth"
 	"github.com/Hitesh-Nagothu/vault-service/data"
 	"github.com/Hitesh-Nagothu/vault-service/handlers"
 	"github.com/Hitesh-Nagothu/vault-service/middlewares"
 	"github.com/Hitesh-Nagothu/vault-service/service"
+	"github.com/Hitesh-Nagothu/vault-service/storage"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -22,10 +30,25 @@ type Config struct {
 		Name string `mapstructure:"name"`
 	} `mapstructure:"database"`
 	Server struct {
-		Port int `mapstructure:"port"`
+		Port              int           `mapstructure:"port"`
+		ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+		ReadTimeout       time.Duration `mapstructure:"read_timeout"`
+		WriteTimeout      time.Duration `mapstructure:"write_timeout"`
+		IdleTimeout       time.Duration `mapstructure:"idle_timeout"`
+		ShutdownTimeout   time.Duration `mapstructure:"shutdown_timeout"`
 	} `mapstructure:"server"`
 }
 
+// used when the server timeouts are not configured. Reads and writes get long enough for large uploads and
+// downloads on slow connections, the header timeout is what guards against slow clients holding connections.
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = 30 * time.Minute
+	DefaultWriteTimeout      = 30 * time.Minute
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultShutdownTimeout   = 30 * time.Second
+)
+
 func main() {
 
 	env := flag.String("env", "default", "The environment to run the server in")
@@ -47,6 +70,11 @@ func main() {
 		log.Fatal("Failed to read config")
 	}
 
+	//the audience is the OAuth client id of a deployment, it can be set from the environment instead of the config file
+	if err := viper.BindEnv("auth.audience", "VAULT_AUTH_AUDIENCE"); err != nil {
+		log.Fatal("Failed to bind auth.audience to the environment")
+	}
+
 	var config Config
 	unmarshallErr := viper.Unmarshal(&config)
 	if unmarshallErr != nil {
@@ -59,38 +87,272 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer logger.Sync()
+
+	//cancelled on SIGINT or SIGTERM, background workers stop with it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var workers sync.WaitGroup
 
 	//db setup
 	db := data.GetMongoDBInstance()
+	if err := db.Migrate(context.Background(), logger); err != nil {
+		logger.Fatal("Failed to migrate the database", zap.Error(err))
+	}
 
-	//ipfs
-	ipfsService := service.NewIPFSService(logger)
+	//storage
+	blobStore, err := storage.NewBlobStore(logger)
+	if err != nil {
+		logger.Fatal("Failed to set up blob storage", zap.Error(err))
+	}
 
 	//chunk
-	chunkRepo := data.NewChunkRepository(db, logger)
+	chunkRepo := data.NewMongoChunkRepository(db, logger)
+	if err := chunkRepo.EnsureIndexes(context.Background()); err != nil {
+		logger.Fatal("Failed to create chunk indexes", zap.Error(err))
+	}
 	chunkService := service.NewChunkService(logger, chunkRepo)
 
 	//user
-	userRepo := data.NewUserRepository(db, logger)
+	userRepo := data.NewMongoUserRepository(db, logger)
+	if err := userRepo.EnsureIndexes(context.Background()); err != nil {
+		logger.Fatal("Failed to create user indexes", zap.Error(err))
+	}
 	userService := service.NewUserService(logger, userRepo)
 	userHandler := handlers.NewUser(logger, userService)
 
 	//file
-	fileRepo := data.NewFileRepository(db, logger)
-	fileService := service.NewFileService(logger, fileRepo, ipfsService, chunkService, userService)
+	fileRepo := data.NewMongoFileRepository(db, logger)
+	if err := fileRepo.EnsureIndexes(context.Background()); err != nil {
+		logger.Fatal("Failed to create file indexes", zap.Error(err))
+	}
+	unitOfWork := data.NewMongoUnitOfWork(db, logger)
+	fileService := service.NewFileService(logger, fileRepo, unitOfWork, blobStore, chunkService, userService)
 	fileHandler := handlers.NewFile(logger, fileService)
+	fileVersionsHandler := handlers.NewFileVersions(logger, fileService)
+	fileMetadataHandler := handlers.NewFileMetadata(logger, fileService)
+	fileListHandler := handlers.NewFileList(logger, fileService)
+	trashHandler := handlers.NewTrash(logger, fileService)
+	fileSharesHandler := handlers.NewFileShares(logger, fileService)
+	sharedFilesHandler := handlers.NewSharedFiles(logger, fileService)
+
+	shareLinkRepo := data.NewMongoShareLinkRepository(db, logger)
+	if err := shareLinkRepo.EnsureIndexes(context.Background()); err != nil {
+		logger.Fatal("Failed to create share link indexes", zap.Error(err))
+	}
+	shareLinkService := service.NewShareLinkService(logger, shareLinkRepo, fileService)
+	shareLinksHandler := handlers.NewShareLinks(logger, shareLinkService)
+	publicShareHandler := handlers.NewPublicShare(logger, shareLinkService, fileService)
+
+	//admin, roles are stored on the user so admins listed in config are granted the role on startup
+	for _, email := range viper.GetStringSlice("admin.emails") {
+		if err := userService.GrantRole(context.Background(), email, service.RoleAdmin); err != nil {
+			logger.Fatal("Failed to grant the admin role", zap.String("email", email), zap.Error(err))
+		}
+	}
+	garbageCollector := service.NewGarbageCollector(logger, fileService)
+	scrubber := service.NewScrubber(logger, chunkService, blobStore)
+	adminService := service.NewAdminService(logger, userService, fileService, garbageCollector, scrubber)
+	adminHandler := handlers.NewAdmin(logger, adminService)
+
+	//background workers
+	trashPurger := service.NewTrashPurger(logger, fileService)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		trashPurger.Run(ctx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		garbageCollector.Run(ctx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		scrubber.Run(ctx)
+	}()
+
+	//auth, authenticators are consulted in this order
+	authenticators := []auth.Authenticator{}
+
+	if len(viper.GetString("auth.audience")) > 0 {
+		tokenValidator, jwks, err := auth.NewTokenValidatorFromConfig(context.Background(), logger)
+		if err != nil {
+			logger.Fatal("Failed to set up token validation", zap.Error(err))
+		}
+		jwksRefresh := viper.GetDuration("auth.jwks_refresh")
+		if jwksRefresh <= 0 {
+			jwksRefresh = auth.DefaultJWKSRefresh
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			jwks.Run(ctx, jwksRefresh)
+		}()
+		authenticators = append(authenticators, auth.NewOIDCAuthenticator(tokenValidator))
+	} else if viper.GetString("env") == "default" {
+		logger.Warn("auth.audience not configured, ID token authentication is disabled")
+	} else {
+		//dev tokens are refused outside default and api keys are created by signed in users, nobody could sign in
+		logger.Fatal("auth.audience is required outside the default environment, set it in the config or VAULT_AUTH_AUDIENCE")
+	}
+
+	apiKeyRepo := data.NewMongoAPIKeyRepository(db, logger)
+	if err := apiKeyRepo.EnsureIndexes(context.Background()); err != nil {
+		logger.Fatal("Failed to create api key indexes", zap.Error(err))
+	}
+	apiKeyService := service.NewAPIKeyService(logger, apiKeyRepo)
+	apiKeyHandler := handlers.NewAPIKey(logger, apiKeyService)
+	authenticators = append(authenticators, auth.NewAPIKeyAuthenticator(apiKeyService))
+
+	//static tokens never leave local development
+	if devTokens := viper.GetStringMapString("auth.dev_tokens"); viper.GetString("env") == "default" && len(devTokens) > 0 {
+		logger.Warn("Static dev tokens enabled", zap.Int("tokens", len(devTokens)))
+		authenticators = append(authenticators, auth.NewStaticTokenAuthenticator(devTokens))
+	}
 
 	handler := middlewares.NewMiddlewareHandler()
-	handler.Use(middlewares.AuthMiddleware)
-	handler.Handle("/file", fileHandler)
-	handler.Handle("/user", userHandler)
 
-	serverAddr := fmt.Sprintf(":%s", strconv.Itoa(config.Server.Port))
-	serverErr := http.ListenAndServe(serverAddr, handler)
-	if serverErr != nil {
-		log.Fatal("Server error: ", err)
+	//authenticated routes. The query string routes (/file?id=) are kept next to the path parameter ones
+	api := handler.Group("", middlewares.NewAuthMiddleware(logger, userService, authenticators...))
+	api.Handle("/file", fileHandler)
+	api.Handle("/file/versions", fileVersionsHandler)
+	api.Get("/file/metadata", fileMetadataHandler)
+	api.Handle("/file/shares", fileSharesHandler)
+	api.Handle("/file/links", shareLinksHandler)
+	api.Get("/files", fileListHandler)
+	api.Post("/files", fileHandler)
+	api.Get("/files/shared", sharedFilesHandler)
+	api.Get("/files/{id}", fileHandler)
+	api.Put("/files/{id}", fileHandler)
+	api.Delete("/files/{id}", fileHandler)
+	api.Handle("/files/{id}/versions", fileVersionsHandler)
+	api.Get("/files/{id}/metadata", fileMetadataHandler)
+	api.Handle("/files/{id}/shares", fileSharesHandler)
+	api.Handle("/files/{id}/links", shareLinksHandler)
+	api.Handle("/shared", sharedFilesHandler)
+	api.Handle("/user", userHandler)
+	api.Handle("/trash", trashHandler)
+	api.Post("/trash/{id}", trashHandler)
+	api.Delete("/trash/{id}", trashHandler)
+	api.Handle("/apikeys", apiKeyHandler)
+	api.Delete("/apikeys/{id}", apiKeyHandler)
+
+	admin := api.Group("/admin", middlewares.NewRoleMiddleware(service.RoleAdmin))
+	admin.Get("/users", http.HandlerFunc(adminHandler.ListUsers))
+	admin.Get("/files", http.HandlerFunc(adminHandler.InspectFile))
+	admin.Get("/files/{id}", http.HandlerFunc(adminHandler.InspectFile))
+	admin.Get("/quotas", http.HandlerFunc(adminHandler.GetQuota))
+	admin.Put("/quotas", http.HandlerFunc(adminHandler.SetQuota))
+	admin.Get("/storage", http.HandlerFunc(adminHandler.GetStorageStats))
+	admin.Get("/gc", http.HandlerFunc(adminHandler.GetGCReport))
+	admin.Post("/gc", http.HandlerFunc(adminHandler.RunGC))
+	admin.Get("/scrub", http.HandlerFunc(adminHandler.GetScrubReport))
+
+	//pre-signed urls carry their own credential, the signature, in place of the bearer token
+	if len(viper.GetStringMapString("signing.keys")) > 0 {
+		urlSigner, err := auth.NewURLSignerFromConfig()
+		if err != nil {
+			logger.Fatal("Failed to set up url signing", zap.Error(err))
+		}
+		presignService := service.NewPresignService(logger, urlSigner, fileService)
+		presignHandler := handlers.NewPresign(logger, presignService)
+		api.Post("/file/presign", presignHandler)
+		api.Post("/files/{id}/presign", presignHandler)
+
+		signed := handler.Group("/signed")
+		signed.With(middlewares.NewSignatureMiddleware(logger, urlSigner, userService, auth.OperationDownload)).
+			Get("/download", handlers.NewSignedDownload(logger, fileService))
+		signed.With(middlewares.NewSignatureMiddleware(logger, urlSigner, userService, auth.OperationUpload)).
+			Put("/upload", handlers.NewSignedUpload(logger, fileService))
+	} else {
+		logger.Warn("signing.keys not configured, pre-signed urls are disabled")
+	}
+
+	//public routes, the share token is the credential and nothing else is checked
+	public := handler.Group("")
+	public.Get("/s/{token}", publicShareHandler)
+
+	//probes for the orchestrator
+	healthService := service.NewHealthService(logger)
+	healthService.Register("mongodb", db.Ping)
+	healthService.Register("storage", blobStore.Check)
+	healthHandler := handlers.NewHealth(logger, healthService)
+	public.Get("/healthz", http.HandlerFunc(healthHandler.Live))
+	public.Get("/readyz", http.HandlerFunc(healthHandler.Ready))
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%s", strconv.Itoa(config.Server.Port)),
+		Handler:           handler,
+		ReadHeaderTimeout: durationOrDefault(config.Server.ReadHeaderTimeout, DefaultReadHeaderTimeout),
+		ReadTimeout:       durationOrDefault(config.Server.ReadTimeout, DefaultReadTimeout),
+		WriteTimeout:      durationOrDefault(config.Server.WriteTimeout, DefaultWriteTimeout),
+		IdleTimeout:       durationOrDefault(config.Server.IdleTimeout, DefaultIdleTimeout),
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("Server listening", zap.String("addr", server.Addr))
+		serverErr <- server.ListenAndServe()
+	}()
+
+	failed := false
+	select {
+	case err := <-serverErr:
+		//ErrServerClosed only follows a Shutdown, anything else means the server could not listen or serve
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Server error", zap.Error(err))
+			failed = true
+		}
+		stop()
+	case <-ctx.Done():
+		logger.Info("Shutdown signal received, draining in-flight requests")
+	}
+
+	shutdown(logger, server, db, &workers, durationOrDefault(config.Server.ShutdownTimeout, DefaultShutdownTimeout))
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// shutdown stops accepting requests and waits up to the timeout for in-flight ones, uploads included,
+// then waits for the background workers, whose context is already cancelled, and disconnects from MongoDB
+func shutdown(logger *zap.Logger, server *http.Server, db *data.MongoDB, workers *sync.WaitGroup, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("In-flight requests did not finish before the shutdown deadline", zap.Error(err))
+		server.Close()
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		logger.Error("Background workers did not stop before the shutdown deadline")
+	}
+
+	//a fresh deadline, the drain may have used up the first one
+	disconnectCtx, disconnectCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer disconnectCancel()
+	if err := db.Disconnect(disconnectCtx); err != nil {
+		logger.Error("Failed to disconnect from MongoDB", zap.Error(err))
+	}
+
+	logger.Info("Shutdown complete")
+	logger.Sync()
+}
+
+func durationOrDefault(configured time.Duration, fallback time.Duration) time.Duration {
+	if configured <= 0 {
+		return fallback
 	}
+	return configured
 }
 
 func GetLogger(env string) (*zap.Logger, error) {
@@ -2,69 +2,60 @@ package middlewares
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
+	"errors"
 	"net/http"
-	"strings"
-)
-
-// AuthMiddleware is a middleware function to authenticate access tokens
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		token := r.Header.Get("Authorization")
-		parts := strings.Split(token, " ")
-
-		// Check if the token is in the expected format
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, "Unauthorized")
-			return
-		}
-
-		accessToken := parts[1]
-
-		url := fmt.Sprintf("https://www.googleapis.com/oauth2/v3/tokeninfo?access_token=%s", accessToken)
 
-		tokenValidityReq, tokenValidityErr := http.NewRequest("GET", url, nil)
-		if tokenValidityErr != nil {
-			log.Fatal("failed to create token validity request")
-			return
-		}
-
-		client := http.Client{}
-		resp, err := client.Do(tokenValidityReq)
-		if err != nil {
-			log.Fatal("Request failed:", err)
-			return
-		}
-		defer resp.Body.Close()
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatal("Failed to read response:", err)
-			return
-		}
-
-		var googleResponseData map[string]interface{}
-		err = json.Unmarshal(body, &googleResponseData)
-		if err != nil {
-			log.Fatal("Failed to parse JSON response:", err)
-			return
-		}
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/auth"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.uber.org/zap"
+)
 
-		email, ok := googleResponseData["email"].(string)
-		if !ok {
-			fmt.Fprintf(w, "Something went wrong. Email not found")
-			return
-		}
+// UserResolver maps an authenticated email to its user, creating the user on first sight
+type UserResolver interface {
+	GetOrCreateUser(ctx context.Context, email string) (data.User, error)
+}
 
-		//TODO Update user last accessed on every request. Figure out if can be done async without blocking request flow
+// NewAuthMiddleware returns a middleware authenticating requests with the first authenticator that recognises
+// the request's credentials. Authenticators are consulted in the given order. The resulting principal, with its
+// user id resolved, is available to handlers through identity.FromContext.
+func NewAuthMiddleware(logger *zap.Logger, users UserResolver, authenticators ...auth.Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(r)
+				if errors.Is(err, auth.ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					logger.Info("Rejected credentials", zap.Error(err))
+					unauthorized(w, r)
+					return
+				}
+
+				//TODO Update user last accessed on every request. Figure out if can be done async without blocking request flow
+				user, err := users.GetOrCreateUser(r.Context(), principal.Email)
+				if err != nil {
+					logger.Error("Failed to resolve authenticated user", zap.String("email", principal.Email), zap.Error(err))
+					apperror.Write(w, r, apperror.Internal("Something went wrong. Failed to identify user", err))
+					return
+				}
+				principal.UserID = user.ID
+				principal.Roles = user.Roles
+
+				r = r.WithContext(identity.WithPrincipal(r.Context(), principal))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			unauthorized(w, r)
+		})
+	}
+}
 
-		ctx := context.WithValue(r.Context(), "email", email)
-		r = r.WithContext(ctx)
-		next.ServeHTTP(w, r)
-	})
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	apperror.Write(w, r, apperror.New(apperror.KindUnauthorized, "unauthorized", "Missing or invalid credentials"))
 }
@@ -1,37 +1,210 @@
 package middlewares
 
 import (
+	"context"
 	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
 )
 
+// MiddlewareHandler routes requests by method and path. Patterns are paths whose segments are either literal or
+// a {name} parameter, e.g. /files/{id}/versions. When several patterns match a path the one with the most literal
+// segments wins, so /files/shared takes precedence over /files/{id}.
+//
+// Middlewares added with Use apply to every route, whenever it was registered. Route groups add their own
+// middlewares on top of their parent's, so routes with different chains, e.g. public and authenticated ones,
+// live side by side in separate groups.
 type MiddlewareHandler struct {
-	mux         *http.ServeMux
+	root   *RouteGroup
+	routes []*route
+}
+
+// RouteGroup registers routes under a path prefix with a middleware stack of its own
+type RouteGroup struct {
+	router      *MiddlewareHandler
+	parent      *RouteGroup
+	prefix      string
 	middlewares []func(http.Handler) http.Handler
 }
 
+type route struct {
+	group    *RouteGroup
+	method   string //empty matches any method
+	segments []string
+	literals int
+	handler  http.Handler
+}
+
 // TODO: change to singleton
 func NewMiddlewareHandler() *MiddlewareHandler {
-	return &MiddlewareHandler{
-		mux: http.NewServeMux(),
-	}
+	mh := &MiddlewareHandler{}
+	mh.root = &RouteGroup{router: mh}
+	return mh
 }
 
 func (mh *MiddlewareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	//direct the request to the embedded servemux
-	mh.mux.ServeHTTP(w, r)
+	path := splitPath(r.URL.Path)
+
+	var matched *route
+	var matchedParams map[string]string
+	var allowed []string
+	for _, candidate := range mh.routes {
+		params, ok := candidate.match(path)
+		if !ok {
+			continue
+		}
+		if len(candidate.method) > 0 && candidate.method != r.Method {
+			allowed = append(allowed, candidate.method)
+			continue
+		}
+		if matched == nil || candidate.literals > matched.literals {
+			matched, matchedParams = candidate, params
+		}
+	}
+
+	if matched == nil {
+		if len(allowed) > 0 {
+			sort.Strings(allowed)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			apperror.Write(w, r, apperror.New(apperror.KindMethodNotAllowed, "method_not_allowed", "Method "+r.Method+" is not allowed on "+r.URL.Path))
+			return
+		}
+		apperror.Write(w, r, apperror.NotFound("route_not_found", "No route for "+r.URL.Path))
+		return
+	}
+
+	if len(matchedParams) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, matchedParams))
+	}
+	matched.group.wrap(matched.handler).ServeHTTP(w, r)
+}
+
+// Use adds a middleware to every route
+func (mh *MiddlewareHandler) Use(middleware func(http.Handler) http.Handler) {
+	mh.root.Use(middleware)
 }
 
+// Handle registers the handler for every method on the pattern, the handler is expected to switch on the method
 func (mh *MiddlewareHandler) Handle(pattern string, handler http.Handler) {
-	// Apply the middlewares to the handler (middlewares added in server init file main.go)
-	for _, middleware := range mh.middlewares {
-		handler = middleware(handler)
+	mh.root.Handle(pattern, handler)
+}
+
+func (mh *MiddlewareHandler) Method(method string, pattern string, handler http.Handler) {
+	mh.root.Method(method, pattern, handler)
+}
+
+// Group returns a group of routes under the prefix, running the given middlewares after the ones added with Use
+func (mh *MiddlewareHandler) Group(prefix string, middlewares ...func(http.Handler) http.Handler) *RouteGroup {
+	return mh.root.Group(prefix, middlewares...)
+}
+
+// Group returns a sub group under the prefix, relative to this group's prefix, which runs this group's
+// middlewares followed by the given ones
+func (group *RouteGroup) Group(prefix string, middlewares ...func(http.Handler) http.Handler) *RouteGroup {
+	return &RouteGroup{
+		router:      group.router,
+		parent:      group,
+		prefix:      group.prefix + strings.TrimSuffix(prefix, "/"),
+		middlewares: middlewares,
 	}
+}
 
-	// Register the handler with the embedded servermux
-	mh.mux.Handle(pattern, handler)
+// With returns a sub group with the same prefix and the extra middlewares, for middlewares specific to a route
+func (group *RouteGroup) With(middlewares ...func(http.Handler) http.Handler) *RouteGroup {
+	return group.Group("", middlewares...)
 }
 
-func (mh *MiddlewareHandler) Use(middleware func(http.Handler) http.Handler) {
-	// Append the middleware to the middlewares slice
-	mh.middlewares = append(mh.middlewares, middleware)
+func (group *RouteGroup) Use(middleware func(http.Handler) http.Handler) {
+	group.middlewares = append(group.middlewares, middleware)
+}
+
+func (group *RouteGroup) Handle(pattern string, handler http.Handler) {
+	group.Method("", pattern, handler)
+}
+
+func (group *RouteGroup) Method(method string, pattern string, handler http.Handler) {
+	segments := splitPath(group.prefix + pattern)
+	literals := 0
+	for _, segment := range segments {
+		if !isParam(segment) {
+			literals++
+		}
+	}
+	group.router.routes = append(group.router.routes, &route{
+		group:    group,
+		method:   method,
+		segments: segments,
+		literals: literals,
+		handler:  handler,
+	})
+}
+
+func (group *RouteGroup) Get(pattern string, handler http.Handler) {
+	group.Method(http.MethodGet, pattern, handler)
+}
+
+func (group *RouteGroup) Post(pattern string, handler http.Handler) {
+	group.Method(http.MethodPost, pattern, handler)
+}
+
+func (group *RouteGroup) Put(pattern string, handler http.Handler) {
+	group.Method(http.MethodPut, pattern, handler)
+}
+
+func (group *RouteGroup) Delete(pattern string, handler http.Handler) {
+	group.Method(http.MethodDelete, pattern, handler)
+}
+
+// wrap applies the middlewares of the group and its parents, the root's running first.
+// It runs per request, so middlewares added after a route was registered still apply to it.
+func (group *RouteGroup) wrap(handler http.Handler) http.Handler {
+	for current := group; current != nil; current = current.parent {
+		for i := len(current.middlewares) - 1; i >= 0; i-- {
+			handler = current.middlewares[i](handler)
+		}
+	}
+	return handler
+}
+
+func (rt *route) match(path []string) (map[string]string, bool) {
+	if len(path) != len(rt.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, segment := range rt.segments {
+		if isParam(segment) {
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[segment[1:len(segment)-1]] = path[i]
+			continue
+		}
+		if segment != path[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func isParam(segment string) bool {
+	return len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// splitPath splits the path into its segments, a trailing slash is ignored
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+type pathParamsKey struct{}
+
+// PathParam returns the value of the {name} segment of the route pattern the request matched, empty if none
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
 }
@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+)
+
+// NewRoleMiddleware returns a middleware rejecting principals without the global role. It has to run after
+// authentication. Services check roles themselves, this keeps whole route groups closed off on top of that.
+func NewRoleMiddleware(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := identity.FromContext(r.Context())
+			if !ok || !principal.HasRole(role) {
+				apperror.Write(w, r, apperror.New(apperror.KindForbidden, "forbidden", "The "+role+" role is required"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
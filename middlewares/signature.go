@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/auth"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.uber.org/zap"
+)
+
+// NewSignatureMiddleware returns a middleware for routes reached through pre-signed URLs instead of a bearer token.
+// The URL must be signed for the given operation. The request runs as the user who minted the URL, so permission
+// checks still apply and a user losing access also invalidates the URLs they minted.
+func NewSignatureMiddleware(logger *zap.Logger, signer *auth.URLSigner, users UserResolver, operation string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			grant, err := signer.Verify(r.URL.Query(), time.Now())
+			if err != nil {
+				logger.Info("Rejected signed url", zap.String("path", r.URL.Path), zap.Error(err))
+				if errors.Is(err, auth.ErrSignatureExpired) {
+					apperror.Write(w, r, apperror.New(apperror.KindGone, "signature_expired", err.Error()))
+					return
+				}
+				apperror.Write(w, r, apperror.New(apperror.KindForbidden, "invalid_signature", err.Error()))
+				return
+			}
+			if grant.Operation != operation {
+				logger.Info("Signed url used for another operation", zap.String("signed_for", grant.Operation), zap.String("operation", operation))
+				apperror.Write(w, r, apperror.New(apperror.KindForbidden, "invalid_signature", auth.ErrSignatureInvalid.Error()))
+				return
+			}
+
+			user, err := users.GetOrCreateUser(r.Context(), grant.Email)
+			if err != nil {
+				logger.Error("Failed to resolve user of signed url", zap.String("email", grant.Email), zap.Error(err))
+				apperror.Write(w, r, apperror.Internal("Something went wrong. Failed to identify user", err))
+				return
+			}
+			principal := identity.Principal{
+				UserID:     user.ID,
+				Email:      user.Email,
+				AuthMethod: auth.MethodSignedURL,
+				Roles:      user.Roles,
+			}
+
+			ctx := identity.WithPrincipal(r.Context(), principal)
+			r = r.WithContext(auth.WithSignedURL(ctx, grant))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
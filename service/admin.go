@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUserNotFound       = apperror.NotFound("user_not_found", "user not found")
+	ErrInvalidUserRequest = apperror.Invalid("invalid_user_request", "invalid cursor, limit or quota")
+)
+
+// AdminService holds the operations reserved to principals with the admin role, every method checks it
+type AdminService struct {
+	logger           *zap.Logger
+	userService      *UserService
+	fileService      *FileService
+	garbageCollector *GarbageCollector
+	scrubber         *Scrubber
+}
+
+func NewAdminService(logger *zap.Logger, userService *UserService, fileService *FileService, garbageCollector *GarbageCollector, scrubber *Scrubber) *AdminService {
+	return &AdminService{
+		logger:           logger,
+		userService:      userService,
+		fileService:      fileService,
+		garbageCollector: garbageCollector,
+		scrubber:         scrubber,
+	}
+}
+
+type UserInfo struct {
+	ID             string    `json:"id"`
+	Email          string    `json:"email"`
+	Roles          []string  `json:"roles,omitempty"`
+	Files          int       `json:"files"`
+	LastAccessedOn time.Time `json:"last_accessed_on"`
+}
+
+// UserPage is a page of users, NextCursor is empty on the last page
+type UserPage struct {
+	Users      []UserInfo `json:"users"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+type QuotaInfo struct {
+	Email           string `json:"email"`
+	QuotaBytes      int64  `json:"quota_bytes"` //0 means unlimited
+	UsageBytes      int64  `json:"usage_bytes"`
+	DedupSavedBytes int64  `json:"dedup_saved_bytes"` //part of the usage not stored twice, see FileService.DedupSavings
+}
+
+// StorageStats is the storage used across all users, SavedBytes is what deduplication saved
+type StorageStats struct {
+	Chunks          int64 `json:"chunks"`
+	StoredBytes     int64 `json:"stored_bytes"`
+	ReferencedBytes int64 `json:"referenced_bytes"`
+	DedupSavedBytes int64 `json:"dedup_saved_bytes"`
+}
+
+// FileInspection is the full picture of a file for admins, including its owner, collaborators and versions
+type FileInspection struct {
+	FileMetadata
+	OwnerID       string              `json:"owner_id,omitempty"`
+	Collaborators []data.Collaborator `json:"collaborators,omitempty"`
+	Versions      []data.FileVersion  `json:"versions"`
+	DeletedAt     *time.Time          `json:"deleted_at,omitempty"`
+}
+
+// ListUsers returns a page of users ordered by id, the cursor is the id of the last user of the previous page
+func (service *AdminService) ListUsers(ctx context.Context, principal identity.Principal, cursor string, limit int) (UserPage, error) {
+	if err := requireAdmin(principal); err != nil {
+		return UserPage{}, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return UserPage{}, ErrInvalidUserRequest
+	}
+	afterId := primitive.NilObjectID
+	if len(cursor) > 0 {
+		id, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return UserPage{}, ErrInvalidUserRequest
+		}
+		afterId = id
+	}
+
+	//one extra to know whether there is a next page
+	users, err := service.userService.ListUsers(ctx, afterId, int64(limit+1))
+	if err != nil {
+		return UserPage{}, apperror.Internal("something went wrong listing users", err)
+	}
+	hasMore := len(users) > limit
+	if hasMore {
+		users = users[:limit]
+	}
+
+	page := UserPage{Users: []UserInfo{}}
+	for _, user := range users {
+		page.Users = append(page.Users, UserInfo{
+			ID:             user.ID.Hex(),
+			Email:          user.Email,
+			Roles:          user.Roles,
+			Files:          len(user.Files),
+			LastAccessedOn: user.LastAccessedOn,
+		})
+	}
+	if hasMore {
+		page.NextCursor = users[len(users)-1].ID.Hex()
+	}
+	return page, nil
+}
+
+// InspectFile returns the metadata of any file, including files in the trash, whoever owns it. Admins have no role on
+// the file, the content stays readable to its owner and collaborators only.
+func (service *AdminService) InspectFile(ctx context.Context, principal identity.Principal, fileId string) (FileInspection, error) {
+	if err := requireAdmin(principal); err != nil {
+		return FileInspection{}, err
+	}
+
+	file, err := service.fileService.lookupFile(ctx, fileId)
+	if err != nil {
+		return FileInspection{}, err
+	}
+
+	inspection := FileInspection{
+		FileMetadata:  ToFileMetadata(file),
+		Collaborators: file.Collaborators,
+		Versions:      fileVersions(file),
+		DeletedAt:     file.DeletedAt,
+	}
+	if !file.OwnerID.IsZero() {
+		inspection.OwnerID = file.OwnerID.Hex()
+	}
+	service.logger.Info("Admin inspected file", zap.String("admin_email", principal.Email), zap.String("file_id", fileId))
+	return inspection, nil
+}
+
+func (service *AdminService) GetQuota(ctx context.Context, principal identity.Principal, email string) (QuotaInfo, error) {
+	if err := requireAdmin(principal); err != nil {
+		return QuotaInfo{}, err
+	}
+
+	user, err := service.getUser(ctx, email)
+	if err != nil {
+		return QuotaInfo{}, err
+	}
+	usage, err := service.fileService.Usage(ctx, user.ID)
+	if err != nil {
+		return QuotaInfo{}, err
+	}
+	saved, err := service.fileService.DedupSavings(ctx, user.ID)
+	if err != nil {
+		return QuotaInfo{}, err
+	}
+
+	return QuotaInfo{
+		Email:           user.Email,
+		QuotaBytes:      service.fileService.QuotaBytes(user),
+		UsageBytes:      usage,
+		DedupSavedBytes: saved,
+	}, nil
+}
+
+// SetQuota sets the storage quota of the user, 0 resets it to the configured default.
+// Lowering a quota below the current usage only blocks further uploads, nothing is deleted.
+func (service *AdminService) SetQuota(ctx context.Context, principal identity.Principal, email string, quotaBytes int64) (QuotaInfo, error) {
+	if err := requireAdmin(principal); err != nil {
+		return QuotaInfo{}, err
+	}
+	if quotaBytes < 0 {
+		return QuotaInfo{}, ErrInvalidUserRequest
+	}
+
+	if _, err := service.getUser(ctx, email); err != nil {
+		return QuotaInfo{}, err
+	}
+	if err := service.userService.SetQuota(ctx, email, quotaBytes); err != nil {
+		return QuotaInfo{}, apperror.Internal("something went wrong setting the quota", err)
+	}
+
+	service.logger.Info("Admin set user quota", zap.String("admin_email", principal.Email), zap.String("user_email", email), zap.Int64("quota_bytes", quotaBytes))
+	return service.GetQuota(ctx, principal, email)
+}
+
+// StorageStats returns the storage used across all users and what deduplication saved of it
+func (service *AdminService) StorageStats(ctx context.Context, principal identity.Principal) (StorageStats, error) {
+	if err := requireAdmin(principal); err != nil {
+		return StorageStats{}, err
+	}
+
+	stats, err := service.fileService.DedupStats(ctx)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	return StorageStats{
+		Chunks:          stats.Chunks,
+		StoredBytes:     stats.StoredBytes,
+		ReferencedBytes: stats.ReferencedBytes,
+		DedupSavedBytes: stats.ReferencedBytes - stats.StoredBytes,
+	}, nil
+}
+
+// LastGCReport returns the report of the last garbage collection, scheduled or not
+func (service *AdminService) LastGCReport(ctx context.Context, principal identity.Principal) (GCReport, error) {
+	if err := requireAdmin(principal); err != nil {
+		return GCReport{}, err
+	}
+	return service.garbageCollector.LastReport()
+}
+
+// RunGC runs a garbage collection now and returns its report
+func (service *AdminService) RunGC(ctx context.Context, principal identity.Principal, dryRun bool) (GCReport, error) {
+	if err := requireAdmin(principal); err != nil {
+		return GCReport{}, err
+	}
+
+	service.logger.Info("Admin started garbage collection", zap.String("admin_email", principal.Email), zap.Bool("dry_run", dryRun))
+	return service.garbageCollector.Collect(ctx, dryRun)
+}
+
+// LastScrubReport returns the report of the last integrity scrubbing pass, with the alerts it raised
+func (service *AdminService) LastScrubReport(ctx context.Context, principal identity.Principal) (ScrubReport, error) {
+	if err := requireAdmin(principal); err != nil {
+		return ScrubReport{}, err
+	}
+	return service.scrubber.LastReport()
+}
+
+func (service *AdminService) getUser(ctx context.Context, email string) (data.User, error) {
+	user, err := service.userService.GetUser(ctx, email)
+	if errors.Is(err, data.ErrNotFound) {
+		return data.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return data.User{}, apperror.Internal("something went wrong getting the user", err)
+	}
+	return user, nil
+}
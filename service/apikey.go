@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	apiKeyPrefix       = "vk_"
+	apiKeyDisplayChars = 10
+	apiKeyTouchAfter   = time.Minute //last use is recorded at most this often, not on every request
+)
+
+var (
+	ErrAPIKeyNotFound = apperror.NotFound("api_key_not_found", "api key not found")
+	ErrInvalidAPIKey  = apperror.New(apperror.KindUnauthorized, "invalid_api_key", "invalid or revoked api key")
+	ErrInvalidScope   = apperror.Invalid("invalid_scope", "unknown api key scope")
+)
+
+type APIKeyService struct {
+	repo   data.APIKeyRepository
+	logger *zap.Logger
+}
+
+func NewAPIKeyService(logger *zap.Logger, repo data.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{
+		logger: logger,
+		repo:   repo,
+	}
+}
+
+// APIKeyInfo describes a key without the key itself
+type APIKeyInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatedAPIKey is returned once on creation, it is the only time the key is visible
+type CreatedAPIKey struct {
+	APIKeyInfo
+	Key string `json:"key"`
+}
+
+// CreateKey creates a key acting as the principal, limited to the scopes if any are given. Keys are managed with
+// unrestricted credentials only, a limited key cannot create a broader one.
+func (service *APIKeyService) CreateKey(ctx context.Context, principal identity.Principal, name string, scopes []string) (CreatedAPIKey, error) {
+	if err := requireUnrestricted(principal); err != nil {
+		return CreatedAPIKey{}, err
+	}
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			return CreatedAPIKey{}, ErrInvalidScope.WithMessage("unknown scope " + scope + ", scopes are read, write, delete, share and admin")
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		service.logger.Error("Failed to generate api key", zap.Error(err))
+		return CreatedAPIKey{}, apperror.Internal("something went wrong creating the api key", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	created, err := service.repo.Add(ctx, data.APIKey{
+		OwnerEmail: principal.Email,
+		Name:       name,
+		Prefix:     key[:apiKeyDisplayChars],
+		KeyHash:    hashSecret(key),
+		Scopes:     scopes,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return CreatedAPIKey{}, apperror.Internal("something went wrong creating the api key", err)
+	}
+
+	return CreatedAPIKey{APIKeyInfo: toAPIKeyInfo(created), Key: key}, nil
+}
+
+func (service *APIKeyService) ListKeys(ctx context.Context, principal identity.Principal) ([]APIKeyInfo, error) {
+	if err := requireUnrestricted(principal); err != nil {
+		return nil, err
+	}
+	keys, err := service.repo.ListByOwner(ctx, principal.Email)
+	if err != nil {
+		return nil, apperror.Internal("something went wrong listing api keys", err)
+	}
+
+	infos := []APIKeyInfo{}
+	for _, key := range keys {
+		infos = append(infos, toAPIKeyInfo(key))
+	}
+	return infos, nil
+}
+
+func (service *APIKeyService) RevokeKey(ctx context.Context, principal identity.Principal, keyId string) error {
+	if err := requireUnrestricted(principal); err != nil {
+		return err
+	}
+	keyDocumentId, err := primitive.ObjectIDFromHex(keyId)
+	if err != nil {
+		return ErrAPIKeyNotFound
+	}
+
+	err = service.repo.Revoke(ctx, keyDocumentId, principal.Email)
+	if err != nil {
+		if errors.Is(err, data.ErrAPIKeyNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return apperror.Internal("something went wrong revoking the api key", err)
+	}
+	service.logger.Info("Revoked api key", zap.String("key_id", keyId), zap.String("owner_email", principal.Email))
+	return nil
+}
+
+// VerifyAPIKey returns the email of the owner of a valid, unrevoked key and the scopes the key is limited to
+func (service *APIKeyService) VerifyAPIKey(ctx context.Context, key string) (string, []string, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", nil, ErrInvalidAPIKey
+	}
+
+	stored, err := service.repo.GetByHash(ctx, hashSecret(key))
+	if errors.Is(err, data.ErrAPIKeyNotFound) {
+		return "", nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if stored.RevokedAt != nil {
+		return "", nil, ErrInvalidAPIKey
+	}
+
+	//best effort, a failure to record usage does not fail the request
+	if stored.LastUsedAt == nil || time.Since(*stored.LastUsedAt) >= apiKeyTouchAfter {
+		_ = service.repo.TouchLastUsed(ctx, stored.ID)
+	}
+	return stored.OwnerEmail, stored.Scopes, nil
+}
+
+// hashSecret hashes api keys and share link tokens. They carry 256 bits of randomness, a fast hash is enough
+// to make a leaked table useless
+func hashSecret(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func toAPIKeyInfo(key data.APIKey) APIKeyInfo {
+	return APIKeyInfo{
+		ID:         key.ID.Hex(),
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
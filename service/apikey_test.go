@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Hitesh-Nagothu/vault-service/auth"
+)
+
+func TestAPIKeyVerificationAndRevocation(t *testing.T) {
+	services := newTestServices(t, nil)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	if _, err := services.apiKeyService.CreateKey(ctx, principal, "ci", []string{"read", "upload"}); !errors.Is(err, ErrInvalidScope) {
+		t.Fatalf("CreateKey with an unknown scope: got %v, want ErrInvalidScope", err)
+	}
+	created, err := services.apiKeyService.CreateKey(ctx, principal, "ci", []string{"read"})
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+
+	email, scopes, err := services.apiKeyService.VerifyAPIKey(ctx, created.Key)
+	if err != nil || email != principal.Email || len(scopes) != 1 || scopes[0] != "read" {
+		t.Fatalf("VerifyAPIKey = %s, %v, %v, want the owner and the read scope", email, scopes, err)
+	}
+	keys, err := services.apiKeyService.ListKeys(ctx, principal)
+	if err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Fatalf("ListKeys = %+v, %v, want the key with its last use recorded", keys, err)
+	}
+
+	//a key limited to scopes cannot manage keys
+	limited := principal
+	limited.AuthMethod, limited.Scopes = auth.MethodAPIKey, scopes
+	if _, err := services.apiKeyService.CreateKey(ctx, limited, "broader", nil); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("CreateKey with a limited key: got %v, want ErrForbidden", err)
+	}
+
+	if err := services.apiKeyService.RevokeKey(ctx, principal, created.ID); err != nil {
+		t.Fatalf("RevokeKey: %v", err)
+	}
+	for _, key := range []string{created.Key, apiKeyPrefix + "unknown"} {
+		if _, _, err := services.apiKeyService.VerifyAPIKey(ctx, key); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Fatalf("VerifyAPIKey of %s: got %v, want ErrInvalidAPIKey", key, err)
+		}
+	}
+	other := services.signIn(t, "grace@example.com")
+	if err := services.apiKeyService.RevokeKey(ctx, other, created.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("RevokeKey of another user's key: got %v, want ErrAPIKeyNotFound", err)
+	}
+}
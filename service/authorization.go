@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/auth"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/utility"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// Roles a principal can hold on a file. Admin is the only global role, it is stored on data.User and grants no role
+// on files.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+	RoleAdmin  = "admin"
+)
+
+// Actions checked against the role of the principal on a file
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionWrite  Action = "write"
+	ActionDelete Action = "delete"
+	ActionShare  Action = "share"
+)
+
+// ScopeAdmin limits api keys to admin operations. Keys are limited to file actions by the scope of the same name,
+// a key without scopes is unrestricted.
+const ScopeAdmin = "admin"
+
+var ErrForbidden = apperror.New(apperror.KindForbidden, "forbidden", "not permitted to perform this operation")
+
+// validScopes are the scopes an api key can be limited to
+var validScopes = map[string]bool{
+	string(ActionRead):   true,
+	string(ActionWrite):  true,
+	string(ActionDelete): true,
+	string(ActionShare):  true,
+	ScopeAdmin:           true,
+}
+
+// rolePermissions is the permission matrix. Admins have no role on files, they inspect file metadata through the
+// admin service only and neither read the content nor change it.
+var rolePermissions = map[string][]Action{
+	RoleOwner:  {ActionRead, ActionWrite, ActionDelete, ActionShare},
+	RoleEditor: {ActionRead, ActionWrite},
+	RoleViewer: {ActionRead},
+}
+
+func roleAllows(role string, action Action) bool {
+	for _, allowed := range rolePermissions[role] {
+		if allowed == action {
+			return true
+		}
+	}
+	return false
+}
+
+// getAuthorizedFile returns the file with the given id if the principal has a role on it permitting the action,
+// trash included. Files the principal has no role on are reported as not found to not leak their existence.
+func (fs *FileService) getAuthorizedFile(ctx context.Context, fileId string, principal identity.Principal, action Action) (data.File, error) {
+	if err := requireScope(principal, string(action)); err != nil {
+		return data.File{}, err
+	}
+
+	file, err := fs.lookupFile(ctx, fileId)
+	if err != nil {
+		return data.File{}, err
+	}
+
+	role, err := fs.fileRole(ctx, file, principal)
+	if err != nil {
+		return data.File{}, err
+	}
+	if len(role) == 0 {
+		fs.logger.Error("User has no role on the file", zap.String("user_email", principal.Email), zap.String("file_id", fileId))
+		return data.File{}, ErrFileNotFound
+	}
+	if !roleAllows(role, action) {
+		fs.logger.Error("Role does not permit the action on the file", zap.String("user_email", principal.Email),
+			zap.String("file_id", fileId), zap.String("role", role), zap.String("action", string(action)))
+		return data.File{}, ErrForbidden
+	}
+	return file, nil
+}
+
+// lookupFile returns the file with the given id, trash included, without checking anyone's access to it
+func (fs *FileService) lookupFile(ctx context.Context, fileId string) (data.File, error) {
+	fileDocumentId, err := primitive.ObjectIDFromHex(fileId)
+	if err != nil {
+		fs.logger.Error("Invalid file id", zap.String("file_id", fileId))
+		return data.File{}, ErrFileNotFound
+	}
+
+	file, err := fs.repo.Get(ctx, fileDocumentId)
+	if errors.Is(err, data.ErrNotFound) {
+		return data.File{}, ErrFileNotFound
+	}
+	if err != nil {
+		return data.File{}, apperror.Internal("something went wrong getting the file", err)
+	}
+	return file, nil
+}
+
+// fileRole returns the strongest role the principal has on the file, empty if it has none
+func (fs *FileService) fileRole(ctx context.Context, file data.File, principal identity.Principal) (string, error) {
+	if !file.OwnerID.IsZero() && file.OwnerID == principal.UserID {
+		return RoleOwner, nil
+	}
+	if file.OwnerID.IsZero() {
+		//files uploaded before files carried an owner are only referenced from their owner's file list
+		user, err := fs.userService.GetUser(ctx, principal.Email)
+		if err != nil && !errors.Is(err, data.ErrNotFound) {
+			fs.logger.Error("Failed to get user requesting the file", zap.String("user_email", principal.Email), zap.Error(err))
+			return "", apperror.Internal("something went wrong getting the file", err)
+		}
+		if utility.ContainsId(user.Files, file.ID) {
+			return RoleOwner, nil
+		}
+	}
+
+	//pending shares only carry the email
+	for _, collaborator := range file.Collaborators {
+		if (!collaborator.UserID.IsZero() && collaborator.UserID == principal.UserID) || strings.EqualFold(collaborator.Email, principal.Email) {
+			return collaborator.Role, nil
+		}
+	}
+	return "", nil
+}
+
+// requireAdmin fails with ErrForbidden unless the principal holds the admin role, and its scopes cover admin operations
+func requireAdmin(principal identity.Principal) error {
+	if !principal.HasRole(RoleAdmin) {
+		return ErrForbidden
+	}
+	return requireScope(principal, ScopeAdmin)
+}
+
+// requireScope fails with ErrForbidden if the principal is an api key whose scopes do not cover the scope
+func requireScope(principal identity.Principal, scope string) error {
+	if principal.AuthMethod == auth.MethodAPIKey && !principal.HasScope(scope) {
+		return ErrForbidden.WithMessage("the api key is not permitted to " + scope)
+	}
+	return nil
+}
+
+// requireUnrestricted fails with ErrForbidden if the principal is an api key limited to scopes, e.g. so a limited
+// api key cannot create an unlimited one
+func requireUnrestricted(principal identity.Principal) error {
+	if principal.AuthMethod == auth.MethodAPIKey && len(principal.Scopes) > 0 {
+		return ErrForbidden.WithMessage("not permitted with an api key limited to scopes")
+	}
+	return nil
+}
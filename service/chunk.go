@@ -1,31 +1,70 @@
 package service
 
 import (
+	"context"
+	"time"
+
 	"github.com/Hitesh-Nagothu/vault-service/data"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
 type ChunkService struct {
-	repo   *data.ChunkRepository
+	repo   data.ChunkRepository
 	logger *zap.Logger
 }
 
-func NewChunkService(logger *zap.Logger, repo *data.ChunkRepository) *ChunkService {
+func NewChunkService(logger *zap.Logger, repo data.ChunkRepository) *ChunkService {
 	return &ChunkService{
 		logger: logger,
 		repo:   repo,
 	}
 }
 
-func (cs *ChunkService) CreateChunk(hash string) (data.Chunk, error) {
-	newChunk := data.Chunk{
-		Hash: hash,
+// AcquireChunk takes a reference on the stored content, recording the chunk the first time the content is used
+func (cs *ChunkService) AcquireChunk(ctx context.Context, hash string, size int64, digest string) (data.Chunk, error) {
+	chunk, err := cs.repo.Acquire(ctx, hash, size, digest)
+	if err != nil {
+		cs.logger.Error("Something went wrong acquiring chunk", zap.String("hash", hash), zap.Error(err))
+		return data.Chunk{}, err
+	}
+	if chunk.RefCount > 1 {
+		cs.logger.Debug("Deduplicated chunk", zap.String("hash", hash), zap.Int64("size", size), zap.Int64("references", chunk.RefCount))
+	}
+	return chunk, nil
+}
+
+// AddReferences takes a reference on the chunks for every time their ids are listed, for versions reusing them
+func (cs *ChunkService) AddReferences(ctx context.Context, chunkIds []primitive.ObjectID) error {
+	return cs.repo.AddReferences(ctx, chunkIds)
+}
+
+// ReleaseChunks drops the references of the chunk ids and returns the chunks no longer referenced, which are deleted
+func (cs *ChunkService) ReleaseChunks(ctx context.Context, chunkIds []primitive.ObjectID) ([]data.Chunk, error) {
+	return cs.repo.Release(ctx, chunkIds)
+}
+
+// ReleaseContent hands the content of the chunks to the garbage collector, which removes it once it stayed
+// unreferenced for the grace period
+func (cs *ChunkService) ReleaseContent(ctx context.Context, chunks []data.Chunk) error {
+	if len(chunks) == 0 {
+		return nil
 	}
-	createdChunk, createErr := cs.repo.Add(newChunk)
-	if createErr != nil {
-		cs.logger.Error("Something went wrong creating chunk", zap.Error(createErr))
-		return data.Chunk{}, createErr
+	hashes := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		hashes = append(hashes, chunk.Hash)
 	}
+	return cs.repo.AddReleased(ctx, hashes, time.Now())
+}
+
+func (cs *ChunkService) GetChunks(ctx context.Context, chunkIds []primitive.ObjectID) ([]data.Chunk, error) {
+	return cs.repo.GetMany(ctx, chunkIds)
+}
+
+func (cs *ChunkService) IsReferenced(ctx context.Context, hash string) (bool, error) {
+	return cs.repo.IsReferenced(ctx, hash)
+}
 
-	return createdChunk, nil
+func (cs *ChunkService) Stats(ctx context.Context) (data.ChunkStats, error) {
+	return cs.repo.Stats(ctx)
 }
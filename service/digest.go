@@ -0,0 +1,59 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+)
+
+var ErrDigestMismatch = apperror.Invalid("digest_mismatch", "uploaded content does not match the digest sent with it")
+
+// Digests are the hex digests of a file's content, an empty digest was not computed or not sent
+type Digests struct {
+	SHA256 string
+	MD5    string
+}
+
+// verify fails with ErrDigestMismatch if a digest the client sent differs from the one computed
+func (computed Digests) verify(expected Digests) error {
+	if len(expected.SHA256) > 0 && expected.SHA256 != computed.SHA256 {
+		return ErrDigestMismatch.WithMessage("uploaded content does not match the SHA-256 digest sent with it")
+	}
+	if len(expected.MD5) > 0 && expected.MD5 != computed.MD5 {
+		return ErrDigestMismatch.WithMessage("uploaded content does not match the MD5 digest sent with it")
+	}
+	return nil
+}
+
+// digester computes the SHA-256 and, if asked for, the MD5 of everything written to it
+type digester struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+}
+
+func newDigester(withMD5 bool) *digester {
+	d := &digester{sha256: sha256.New()}
+	if withMD5 {
+		d.md5 = md5.New()
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.sha256.Write(p)
+	if d.md5 != nil {
+		d.md5.Write(p)
+	}
+	return len(p), nil
+}
+
+func (d *digester) digests() Digests {
+	digests := Digests{SHA256: hex.EncodeToString(d.sha256.Sum(nil))}
+	if d.md5 != nil {
+		digests.MD5 = hex.EncodeToString(d.md5.Sum(nil))
+	}
+	return digests
+}
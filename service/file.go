@@ -1,113 +1,270 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
-	"mime/multipart"
+	"mime"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/chunker"
 	"github.com/Hitesh-Nagothu/vault-service/data"
-	"github.com/Hitesh-Nagothu/vault-service/utility"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/storage"
+	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
 type FileService struct {
-	repo         *data.FileRepository
+	maxFileSize  int64
+	defaultQuota int64
+	computeMD5   bool
+	repo         data.FileRepository
+	unitOfWork   data.UnitOfWork
 	logger       *zap.Logger
-	ipfsService  *IPFSService
+	blobStore    storage.BlobStore
 	chunkService *ChunkService
 	userService  *UserService
 }
 
-func NewFileService(logger *zap.Logger, repo *data.FileRepository, ipfsService *IPFSService, chunkService *ChunkService, userService *UserService) *FileService {
+const (
+	DefaultMaxFileSize = 5 * 1024 * 1024 // 5MB in bytes, used when upload.max_size is not configured
+	DefaultQuota       = 0               // unlimited, used when quota.default_bytes is not configured
+
+	releaseTimeout = 10 * time.Second // for recording the content of an upload that did not commit
+)
+
+func NewFileService(logger *zap.Logger, repo data.FileRepository, unitOfWork data.UnitOfWork, blobStore storage.BlobStore, chunkService *ChunkService, userService *UserService) *FileService {
+	maxFileSize := viper.GetInt64("upload.max_size")
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultMaxFileSize
+	}
+	defaultQuota := viper.GetInt64("quota.default_bytes")
+	if defaultQuota <= 0 {
+		defaultQuota = DefaultQuota
+	}
+
 	return &FileService{
+		maxFileSize:  maxFileSize,
+		defaultQuota: defaultQuota,
+		computeMD5:   viper.GetBool("upload.md5"),
 		logger:       logger,
 		repo:         repo,
-		ipfsService:  ipfsService,
+		unitOfWork:   unitOfWork,
+		blobStore:    blobStore,
 		chunkService: chunkService,
 		userService:  userService,
 	}
 }
 
-const (
-	MaxFileSize = 5 * 1024 * 1024 // 5MB in bytes
+var (
+	ErrFileNotFound        = apperror.NotFound("file_not_found", "file not found")
+	ErrFileTooLarge        = apperror.New(apperror.KindTooLarge, "file_too_large", "file size uploaded exceeds the permissible limit")
+	ErrUnsupportedFileType = apperror.New(apperror.KindUnsupportedType, "unsupported_file_type", "unsupported file type uploaded")
 )
 
-func (fs *FileService) CreateFile(file multipart.File, fileHeader *multipart.FileHeader, userEmail string) error {
-
-	if fileHeader.Size > MaxFileSize {
-		return errors.New("file size uploaded exceeds the permissible limit of 5MB")
+// CreateFile streams the content into storage as it is read, so memory use does not grow with the file size.
+// The chunk, file and user writes are committed in a single transaction, content stored for an upload that
+// does not commit is left to the garbage collector. The upload is rejected with ErrDigestMismatch if the content
+// does not match a digest in expected.
+func (fs *FileService) CreateFile(ctx context.Context, content io.Reader, fileName string, principal identity.Principal, expected Digests) error {
+	if err := requireScope(principal, string(ActionWrite)); err != nil {
+		return err
 	}
 
-	fileType := fs.GetFileType(fileHeader.Filename)
+	fileType := fs.GetFileType(fileName)
 	fileType, isAllowed := fs.IsAllowedFileType(fileType)
 	if !isAllowed {
 		fs.logger.Error("Invalid file type", zap.String("requested_file_type", fileType))
-		return errors.New("unsupported file type uploaded")
+		return ErrUnsupportedFileType
 	}
 
-	filebytes, readErr := io.ReadAll(file)
-	if readErr != nil {
-		fs.logger.Error("Failed to read file", zap.Error(readErr))
-		return errors.New("something went wrong reading the file")
+	limitedContent := &limitedReader{reader: content, remaining: fs.maxFileSize}
+	chunks, size, digests, storeErr := fs.storeChunks(limitedContent, fs.computeMD5 || len(expected.MD5) > 0)
+	if storeErr != nil {
+		fs.releaseContent(chunks)
+		if errors.Is(storeErr, ErrFileTooLarge) {
+			fs.logger.Error("File exceeds the size limit", zap.String("fileName", fileName), zap.Int64("max_size", fs.maxFileSize))
+			return fs.errFileTooLarge()
+		}
+		fs.logger.Error("Failed to store file content", zap.String("fileName", fileName), zap.String("fileType", fileType), zap.Error(storeErr))
+		return apperror.StorageUnavailable("something went wrong storing the file", storeErr)
 	}
 
-	fileHash, hashGenErr := fs.GetIPFSHashForFile(filebytes)
-	if hashGenErr != nil {
-		fs.logger.Error("Failed to generate hash for file", zap.String("fileName", fileHeader.Filename), zap.String("fileType", fileType))
-		return errors.New("something went wrong processing the file")
+	if err := digests.verify(expected); err != nil {
+		fs.logger.Error("Uploaded content does not match its digest", zap.String("fileName", fileName), zap.String("sha256", digests.SHA256), zap.String("md5", digests.MD5))
+		fs.releaseContent(chunks)
+		return err
 	}
 
-	//Note: Following only a single chunk for a file, will likely add the chunk implementation later if needed
-
-	//insert the new chunk
-	createdChunk, createChunkErr := fs.chunkService.CreateChunk(fileHash)
-	if createChunkErr != nil {
-		fs.logger.Error("Failed to get a new chunk for file. Aborting file upload")
-		return errors.New("something went wrong processing the file")
+	if err := fs.checkQuota(ctx, principal.UserID, size); err != nil {
+		fs.releaseContent(chunks)
+		return err
 	}
 
-	newFile := data.File{
-		Name: fileHeader.Filename,
-		Type: fileType,
-		ChunkIDs: []primitive.ObjectID{
-			createdChunk.ID,
-		},
-	}
+	var createdFile data.File
+	txErr := fs.unitOfWork.Do(ctx, func(txCtx context.Context) error {
+		chunkIds, err := fs.recordChunks(txCtx, chunks)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		firstVersion := data.FileVersion{
+			Number:     1,
+			ChunkIDs:   chunkIds,
+			Size:       size,
+			Hash:       digests.SHA256,
+			MD5:        digests.MD5,
+			UploadedBy: principal.Email,
+			CreatedAt:  now,
+		}
+		newFile := data.File{
+			OwnerID:   principal.UserID,
+			Name:      fileName,
+			Type:      fileType,
+			Size:      size,
+			Hash:      digests.SHA256,
+			MD5:       digests.MD5,
+			ChunkIDs:  chunkIds,
+			Version:   firstVersion.Number,
+			Versions:  []data.FileVersion{firstVersion},
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		//insert the new file
+		file, err := fs.repo.Add(txCtx, newFile)
+		if err != nil {
+			return err
+		}
+
+		userUpdate := data.User{
+			Files: []primitive.ObjectID{file.ID}, //sending partial object
+		}
+		if err := fs.userService.UpdateUser(txCtx, principal.UserID, userUpdate); err != nil {
+			return err
+		}
 
-	//insert the new file
-	createdFile, createFileErr := fs.repo.Add(newFile)
-	if createFileErr != nil {
-		fs.logger.Error("Failed to create new file. Aborting file upload")
-		return errors.New("something went wrong processing the file")
+		createdFile = file
+		return nil
+	})
+	if txErr != nil {
+		fs.logger.Error("Failed to record the uploaded file. Aborting file upload", zap.String("fileName", fileName), zap.Error(txErr))
+		fs.releaseContent(chunks)
+		return apperror.Internal("something went wrong processing the file", txErr)
 	}
 
-	//get ther user uploading the file
-	user, err := fs.userService.GetUser(userEmail)
-	//TODO check for notfound error else abort
-	if err != nil || utility.IsStructEmpty(user) {
-		user, err = fs.userService.CreateUser(userEmail)
-		fs.logger.Info("Create a new user previously not found", zap.String("user_email", user.Email))
+	fs.logger.Info("File upload successful", zap.String("file_name", createdFile.Name))
+	return nil
+}
+
+// storeChunks splits the content with the configured chunker and puts every chunk in the blob store.
+// Returns the chunks in file order, not yet recorded in the database, with the total size and digests of the content,
+// the MD5 only if withMD5. On failure the chunks stored so far are still returned so the caller can clean them up.
+func (fs *FileService) storeChunks(content io.Reader, withMD5 bool) ([]data.Chunk, int64, Digests, error) {
+	contentDigester := newDigester(withMD5)
+	contentChunker, err := chunker.New(io.TeeReader(content, contentDigester))
+	if err != nil {
+		return nil, 0, Digests{}, err
 	}
 
-	userUpdate := data.User{
-		Files: []primitive.ObjectID{createdFile.ID}, //sending partial object
+	chunks := []data.Chunk{}
+	var size int64
+	for {
+		chunkBytes, err := contentChunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return chunks, 0, Digests{}, err
+		}
+
+		hash, err := fs.blobStore.Put(bytes.NewReader(chunkBytes))
+		if err != nil {
+			return chunks, 0, Digests{}, err
+		}
+
+		chunkDigest := sha256.Sum256(chunkBytes)
+		chunks = append(chunks, data.Chunk{
+			Hash:   hash,
+			Size:   int64(len(chunkBytes)),
+			SHA256: hex.EncodeToString(chunkDigest[:]),
+		})
+		size += int64(len(chunkBytes))
 	}
 
-	updateUserErr := fs.userService.UpdateUser(user.ID, userUpdate)
-	if updateUserErr != nil {
-		fs.logger.Error("Failed to udpate the user owner with new file. Aborting file upload")
-		return errors.New("something went wrong processing the file")
+	return chunks, size, contentDigester.digests(), nil
+}
+
+// recordChunks takes a reference on every stored chunk and returns their ids in file order.
+// Content stored before, by this or any other file, reuses the existing chunk.
+func (fs *FileService) recordChunks(ctx context.Context, chunks []data.Chunk) ([]primitive.ObjectID, error) {
+	chunkIds := []primitive.ObjectID{}
+	for _, chunk := range chunks {
+		acquiredChunk, err := fs.chunkService.AcquireChunk(ctx, chunk.Hash, chunk.Size, chunk.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		chunkIds = append(chunkIds, acquiredChunk.ID)
 	}
+	return chunkIds, nil
+}
 
-	//TODO make chunk storing, file creation and update user with new file transactional
-	fs.logger.Info("File upload successful", zap.String("file_name", createdFile.Name))
-	return nil
+// releaseContent hands the content stored for an upload that did not commit to the garbage collector. Nothing is
+// removed here, a concurrent upload of the same content may have stored it and not committed yet. Runs on its own
+// context, the upload's request may already be cancelled.
+func (fs *FileService) releaseContent(chunks []data.Chunk) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := fs.chunkService.ReleaseContent(ctx, chunks); err != nil {
+		fs.logger.Error("Failed to release the content of an upload that did not commit", zap.Int("chunks", len(chunks)), zap.Error(err))
+	}
 }
 
 func (fs *FileService) GetFileType(filename string) string {
-	return strings.Split(filename, ".")[1]
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+}
+
+// MaxFileSize is the largest upload accepted, in bytes
+func (fs *FileService) MaxFileSize() int64 {
+	return fs.maxFileSize
+}
+
+// errFileTooLarge is ErrFileTooLarge telling the client the limit
+func (fs *FileService) errFileTooLarge() error {
+	return ErrFileTooLarge.WithMessage(fmt.Sprintf("file size uploaded exceeds the permissible limit of %d bytes", fs.maxFileSize))
+}
+
+// limitedReader fails with ErrFileTooLarge once more than remaining bytes are read,
+// unlike io.LimitReader which silently truncates
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, ErrFileTooLarge
+	}
+	//read one byte past the limit to tell an exact fit from an oversized file
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.reader.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return 0, ErrFileTooLarge
+	}
+	return n, err
 }
 
 func (fs *FileService) IsAllowedFileType(fileType string) (string, bool) {
@@ -121,12 +278,125 @@ func (fs *FileService) IsAllowedFileType(fileType string) (string, bool) {
 
 }
 
-func (fs *FileService) GetIPFSHashForFile(fileData []byte) (string, error) {
+// GetFile returns the file with the given id if the principal may read it and it is not in the trash
+func (fs *FileService) GetFile(ctx context.Context, fileId string, principal identity.Principal) (data.File, error) {
+	return fs.getFile(ctx, fileId, principal, ActionRead)
+}
+
+// getFile returns the file with the given id if the principal may perform the action on it, trash excluded
+func (fs *FileService) getFile(ctx context.Context, fileId string, principal identity.Principal, action Action) (data.File, error) {
+	file, err := fs.getAuthorizedFile(ctx, fileId, principal, action)
+	if err != nil {
+		return data.File{}, err
+	}
+	if file.DeletedAt != nil {
+		return data.File{}, ErrFileNotFound
+	}
+	return file, nil
+}
+
+// OpenFile returns a reader streaming the content of the file's current version, see OpenVersion
+func (fs *FileService) OpenFile(ctx context.Context, file data.File) (io.ReadCloser, error) {
+	return fs.OpenVersion(ctx, currentVersion(file))
+}
+
+// OpenVersion returns a reader streaming the content of the version chunk by chunk, in order, without buffering it in memory.
+// Chunks are resolved upfront so a missing chunk is reported before anything is written to the caller.
+func (fs *FileService) OpenVersion(ctx context.Context, version data.FileVersion) (io.ReadCloser, error) {
+	chunks, err := fs.chunkService.GetChunks(ctx, version.ChunkIDs)
+	if err != nil {
+		fs.logger.Error("Failed to resolve chunks for file version", zap.Int("version", version.Number), zap.Error(err))
+		return nil, apperror.Internal("something went wrong reading the file", err)
+	}
 
-	ipfsInstance := fs.ipfsService.GetIPFSInstance()
-	hash, err := ipfsInstance.AddContent(fileData)
+	return &chunkReader{
+		ctx:    ctx,
+		chunks: chunks,
+		open:   fs.blobStore.Get,
+	}, nil
+}
+
+// OpenVersionRange is OpenVersion limited to length bytes from start. Chunks entirely before start are skipped
+// without being read, only the part of the first chunk before start is read and discarded.
+func (fs *FileService) OpenVersionRange(ctx context.Context, version data.FileVersion, start int64, length int64) (io.ReadCloser, error) {
+	chunks, err := fs.chunkService.GetChunks(ctx, version.ChunkIDs)
 	if err != nil {
-		//TODO
+		fs.logger.Error("Failed to resolve chunks for file version", zap.Int("version", version.Number), zap.Error(err))
+		return nil, apperror.Internal("something went wrong reading the file", err)
+	}
+
+	skip := start
+	for len(chunks) > 0 && chunks[0].Size <= skip {
+		skip -= chunks[0].Size
+		chunks = chunks[1:]
+	}
+
+	reader := &chunkReader{
+		ctx:    ctx,
+		chunks: chunks,
+		open:   fs.blobStore.Get,
+	}
+	if _, err := io.CopyN(io.Discard, reader, skip); err != nil {
+		reader.Close()
+		fs.logger.Error("Failed to seek to the start of the range", zap.Int("version", version.Number), zap.Int64("start", start), zap.Error(err))
+		return nil, apperror.Internal("something went wrong reading the file", err)
+	}
+
+	return &limitedReadCloser{Reader: io.LimitReader(reader, length), Closer: reader}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// chunkReader concatenates the content of chunks, opening each one only once the previous one is exhausted.
+// Reading stops once ctx, the context of the request streaming the content, is done.
+type chunkReader struct {
+	ctx     context.Context
+	chunks  []data.Chunk
+	open    func(ctx context.Context, hash string) (io.ReadCloser, error)
+	current io.ReadCloser
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if cr.current == nil {
+			if len(cr.chunks) == 0 {
+				return 0, io.EOF
+			}
+			reader, err := cr.open(cr.ctx, cr.chunks[0].Hash)
+			if err != nil {
+				return 0, err
+			}
+			cr.current = reader
+			cr.chunks = cr.chunks[1:]
+		}
+
+		n, err := cr.current.Read(p)
+		if err == io.EOF {
+			cr.current.Close()
+			cr.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (cr *chunkReader) Close() error {
+	if cr.current != nil {
+		return cr.current.Close()
+	}
+	return nil
+}
+
+func (fs *FileService) GetContentType(fileType string) string {
+	contentType := mime.TypeByExtension("." + fileType)
+	if len(contentType) == 0 {
+		return "application/octet-stream"
 	}
-	return hash, nil
+	return contentType
 }
@@ -0,0 +1,387 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/auth"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// small chunks so a test upload spans several of them
+var testConfig = map[string]interface{}{
+	"upload.max_size": 4096,
+	"chunking.size":   64,
+}
+
+func TestCreateFileStoresContent(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 20)
+	if err := services.fileService.CreateFile(ctx, strings.NewReader(content), "notes.txt", principal, Digests{}); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{})
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(page.Files) != 1 {
+		t.Fatalf("ListFiles returned %d files, want 1", len(page.Files))
+	}
+	listed := page.Files[0]
+	if listed.Name != "notes.txt" || listed.Type != "txt" || listed.Size != int64(len(content)) || listed.Version != 1 {
+		t.Fatalf("ListFiles returned %+v", listed)
+	}
+
+	user, err := services.userService.GetUser(ctx, principal.Email)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if len(user.Files) != 1 || user.Files[0].Hex() != listed.ID {
+		t.Fatalf("user files = %v, want [%s]", user.Files, listed.ID)
+	}
+
+	if got := readFile(t, services, listed.ID, principal); got != content {
+		t.Fatalf("read back %d bytes that differ from the %d uploaded", len(got), len(content))
+	}
+}
+
+func TestCreateFileRejectsUnsupportedType(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	principal := services.signIn(t, "ada@example.com")
+
+	err := services.fileService.CreateFile(context.Background(), strings.NewReader("#!/bin/sh"), "run.sh", principal, Digests{})
+	if !errors.Is(err, ErrUnsupportedFileType) {
+		t.Fatalf("CreateFile of a .sh file: got %v, want ErrUnsupportedFileType", err)
+	}
+	assertNoFiles(t, services, principal)
+}
+
+func TestCreateFileRejectsOversizeContent(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	principal := services.signIn(t, "ada@example.com")
+
+	content := bytes.Repeat([]byte("a"), 4097)
+	err := services.fileService.CreateFile(context.Background(), bytes.NewReader(content), "big.txt", principal, Digests{})
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("CreateFile over the size limit: got %v, want ErrFileTooLarge", err)
+	}
+	assertNoFiles(t, services, principal)
+}
+
+func TestCreateFileVerifiesDigests(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	content := strings.Repeat("checksummed content\n", 10)
+	sha256Sum := sha256.Sum256([]byte(content))
+	md5Sum := md5.Sum([]byte(content))
+	digests := Digests{SHA256: hex.EncodeToString(sha256Sum[:]), MD5: hex.EncodeToString(md5Sum[:])}
+
+	for _, expected := range []Digests{{SHA256: strings.Repeat("0", 64)}, {SHA256: digests.SHA256, MD5: strings.Repeat("0", 32)}} {
+		err := services.fileService.CreateFile(ctx, strings.NewReader(content), "notes.txt", principal, expected)
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Fatalf("CreateFile with digests %+v: got %v, want ErrDigestMismatch", expected, err)
+		}
+	}
+	assertNoFiles(t, services, principal)
+	if stats, _ := services.chunks.Stats(ctx); stats.Chunks != 0 {
+		t.Fatalf("rejected uploads left %d chunks recorded", stats.Chunks)
+	}
+	if released, _ := services.chunks.ListReleased(ctx, "", 0); len(released) == 0 {
+		t.Fatal("content of rejected uploads was not handed to the garbage collector")
+	}
+
+	if err := services.fileService.CreateFile(ctx, strings.NewReader(content), "notes.txt", principal, digests); err != nil {
+		t.Fatalf("CreateFile with matching digests: %v", err)
+	}
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{})
+	if err != nil || len(page.Files) != 1 {
+		t.Fatalf("ListFiles: %v, %d files", err, len(page.Files))
+	}
+	if page.Files[0].Hash != digests.SHA256 || page.Files[0].MD5 != digests.MD5 {
+		t.Fatalf("recorded hash %q and md5 %q, want %q and %q", page.Files[0].Hash, page.Files[0].MD5, digests.SHA256, digests.MD5)
+	}
+}
+
+func TestCreateFileConcurrentUploadsMergeIntoUser(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	const uploads = 10
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := strings.NewReader(fmt.Sprintf("upload number %d", i))
+			if err := services.fileService.CreateFile(ctx, content, fmt.Sprintf("file-%d.txt", i), principal, Digests{}); err != nil {
+				t.Errorf("CreateFile %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	user, err := services.userService.GetUser(ctx, principal.Email)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if len(user.Files) != uploads {
+		t.Fatalf("user has %d files after %d uploads", len(user.Files), uploads)
+	}
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{Limit: uploads})
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	for _, listed := range page.Files {
+		if !containsId(user.Files, mustObjectId(t, listed.ID)) {
+			t.Fatalf("listed file %s is missing from the user's files", listed.ID)
+		}
+	}
+}
+
+func TestUpdateFileAddsVersion(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	if err := services.fileService.CreateFile(ctx, strings.NewReader("first draft"), "draft.txt", principal, Digests{}); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{})
+	if err != nil || len(page.Files) != 1 {
+		t.Fatalf("ListFiles: %v, %d files", err, len(page.Files))
+	}
+	fileId := page.Files[0].ID
+
+	version, err := services.fileService.UpdateFile(ctx, fileId, strings.NewReader("second draft"), principal, Digests{})
+	if err != nil {
+		t.Fatalf("UpdateFile: %v", err)
+	}
+	if version.Number != 2 {
+		t.Fatalf("UpdateFile created version %d, want 2", version.Number)
+	}
+	if got := readFile(t, services, fileId, principal); got != "second draft" {
+		t.Fatalf("current version reads %q, want the update", got)
+	}
+
+	file, err := services.fileService.GetFile(ctx, fileId, principal)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	first, err := services.fileService.GetVersion(file, 1)
+	if err != nil {
+		t.Fatalf("GetVersion 1: %v", err)
+	}
+	if got := readVersion(t, services, first); got != "first draft" {
+		t.Fatalf("version 1 reads %q, want the original upload", got)
+	}
+}
+
+func TestGetFileOfAnotherUser(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	owner := services.signIn(t, "ada@example.com")
+	other := services.signIn(t, "grace@example.com")
+
+	if err := services.fileService.CreateFile(ctx, strings.NewReader("private"), "private.txt", owner, Digests{}); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+	page, err := services.fileService.ListFiles(ctx, owner, ListFilesRequest{})
+	if err != nil || len(page.Files) != 1 {
+		t.Fatalf("ListFiles: %v, %d files", err, len(page.Files))
+	}
+
+	if _, err := services.fileService.GetFile(ctx, page.Files[0].ID, other); err == nil {
+		t.Fatal("GetFile of another user's file succeeded")
+	}
+	admin := other
+	admin.Roles = []string{RoleAdmin}
+	if _, err := services.fileService.GetFile(ctx, page.Files[0].ID, admin); err == nil {
+		t.Fatal("GetFile of another user's file succeeded for an admin")
+	}
+	assertNoFiles(t, services, other)
+}
+
+func readFile(t *testing.T, services *testServices, fileId string, principal identity.Principal) string {
+	t.Helper()
+	file, err := services.fileService.GetFile(context.Background(), fileId, principal)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	version, err := services.fileService.GetVersion(file, 0)
+	if err != nil {
+		t.Fatalf("GetVersion: %v", err)
+	}
+	return readVersion(t, services, version)
+}
+
+func readVersion(t *testing.T, services *testServices, version data.FileVersion) string {
+	t.Helper()
+	reader, err := services.fileService.OpenVersion(context.Background(), version)
+	if err != nil {
+		t.Fatalf("OpenVersion: %v", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading version %d: %v", version.Number, err)
+	}
+	return string(content)
+}
+
+func assertNoFiles(t *testing.T, services *testServices, principal identity.Principal) {
+	t.Helper()
+	page, err := services.fileService.ListFiles(context.Background(), principal, ListFilesRequest{})
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(page.Files) != 0 {
+		t.Fatalf("ListFiles returned %d files, want none", len(page.Files))
+	}
+}
+
+func mustObjectId(t *testing.T, id string) primitive.ObjectID {
+	t.Helper()
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		t.Fatalf("invalid object id %q: %v", id, err)
+	}
+	return objectId
+}
+
+func TestIdenticalUploadsShareChunks(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	content := strings.Repeat("same content in both files\n", 10)
+	for _, name := range []string{"first.txt", "second.txt"} {
+		if err := services.fileService.CreateFile(ctx, strings.NewReader(content), name, principal, Digests{}); err != nil {
+			t.Fatalf("CreateFile %s: %v", name, err)
+		}
+	}
+
+	stats, err := services.fileService.DedupStats(ctx)
+	if err != nil {
+		t.Fatalf("DedupStats: %v", err)
+	}
+	size := int64(len(content))
+	if stats.StoredBytes != size || stats.ReferencedBytes != 2*size {
+		t.Fatalf("DedupStats = %+v, want %d bytes stored and %d referenced", stats, size, 2*size)
+	}
+	saved, err := services.fileService.DedupSavings(ctx, principal.UserID)
+	if err != nil {
+		t.Fatalf("DedupSavings: %v", err)
+	}
+	if saved != size {
+		t.Fatalf("DedupSavings = %d, want %d", saved, size)
+	}
+
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{Sort: "name", Order: "asc"})
+	if err != nil || len(page.Files) != 2 {
+		t.Fatalf("ListFiles: %v, %d files", err, len(page.Files))
+	}
+	purge := func(fileId string) {
+		t.Helper()
+		if err := services.fileService.DeleteFile(ctx, fileId, principal); err != nil {
+			t.Fatalf("DeleteFile: %v", err)
+		}
+		if err := services.fileService.PurgeFile(ctx, fileId, principal); err != nil {
+			t.Fatalf("PurgeFile: %v", err)
+		}
+	}
+
+	file, err := services.fileService.GetFile(ctx, page.Files[0].ID, principal)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	chunks, err := services.chunks.GetMany(ctx, file.ChunkIDs)
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+
+	//the second file keeps the shared content alive
+	purge(page.Files[0].ID)
+	if got := readFile(t, services, page.Files[1].ID, principal); got != content {
+		t.Fatalf("second file reads %d bytes that differ from the %d uploaded", len(got), len(content))
+	}
+
+	purge(page.Files[1].ID)
+	stats, err = services.fileService.DedupStats(ctx)
+	if err != nil {
+		t.Fatalf("DedupStats: %v", err)
+	}
+	if stats != (data.ChunkStats{}) {
+		t.Fatalf("DedupStats after purging both files = %+v, want nothing stored", stats)
+	}
+
+	//the content is left to the garbage collector, which removes it once it stayed unreferenced for the grace period
+	gc := NewGarbageCollector(zap.NewNop(), services.fileService)
+	if _, err := gc.collect(ctx, time.Now().Add(time.Hour), false); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	for _, chunk := range chunks {
+		if exists, _ := services.blobStore.Exists(chunk.Hash); exists {
+			t.Fatalf("content of chunk %s left in storage after purging both files", chunk.Hash)
+		}
+	}
+}
+
+func TestAPIKeyScopesLimitFileActions(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	if err := services.fileService.CreateFile(ctx, strings.NewReader("report"), "report.txt", principal, Digests{}); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{})
+	if err != nil || len(page.Files) != 1 {
+		t.Fatalf("ListFiles: %v, %d files", err, len(page.Files))
+	}
+	fileId := page.Files[0].ID
+
+	//OAuth scopes of a signed in user are not vault scopes, only api keys are limited
+	signedIn := principal
+	signedIn.AuthMethod, signedIn.Scopes = auth.MethodOIDC, []string{"openid", "email", "profile"}
+	if _, err := services.fileService.UpdateFile(ctx, fileId, strings.NewReader("report v2"), signedIn, Digests{}); err != nil {
+		t.Fatalf("UpdateFile as a signed in user: %v", err)
+	}
+
+	readOnly := principal
+	readOnly.AuthMethod, readOnly.Scopes = auth.MethodAPIKey, []string{string(ActionRead)}
+	if got := readFile(t, services, fileId, readOnly); got != "report v2" {
+		t.Fatalf("read-only key reads %q, want the content", got)
+	}
+	if err := services.fileService.CreateFile(ctx, strings.NewReader("more"), "more.txt", readOnly, Digests{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("CreateFile with a read-only key: got %v, want ErrForbidden", err)
+	}
+	if _, err := services.fileService.UpdateFile(ctx, fileId, strings.NewReader("changed"), readOnly, Digests{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("UpdateFile with a read-only key: got %v, want ErrForbidden", err)
+	}
+	if err := services.fileService.DeleteFile(ctx, fileId, readOnly); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("DeleteFile with a read-only key: got %v, want ErrForbidden", err)
+	}
+	if _, err := services.fileService.ShareFile(ctx, fileId, "grace@example.com", PermissionRead, readOnly); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("ShareFile with a read-only key: got %v, want ErrForbidden", err)
+	}
+}
@@ -0,0 +1,397 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/storage"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultGCInterval = 24 * time.Hour // used when gc.interval is not configured
+	DefaultGCGrace    = time.Hour      // used when gc.grace is not configured
+	DefaultGCDryRun   = true           // used when gc.dry_run is not configured, only a report until turned off
+
+	gcPageSize    = 500
+	gcReportLimit = 100 // ids and errors listed in a report, the counts cover everything
+)
+
+var (
+	ErrGCRunning = apperror.New(apperror.KindConflict, "gc_running", "garbage collection is already running")
+	ErrGCNotRun  = apperror.NotFound("gc_not_run", "garbage collection has not run yet")
+)
+
+// GCReport is what a garbage collection run found and, unless it was a dry run, removed
+type GCReport struct {
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Cutoff     time.Time `json:"cutoff"` //anything newer was left alone
+
+	OrphanedFiles         int      `json:"orphaned_files"`
+	OrphanedFileIDs       []string `json:"orphaned_file_ids,omitempty"`
+	UnreferencedChunks    int      `json:"unreferenced_chunks"`
+	ReclaimedBytes        int64    `json:"reclaimed_bytes"` //size of the chunks removed, a dry run counts the unreferenced chunks
+	OrphanedBlobs         int      `json:"orphaned_blobs"`
+	OrphanedBlobAddresses []string `json:"orphaned_blob_addresses,omitempty"`
+	PendingBlobs          int      `json:"pending_blobs"` //found unreferenced too recently to remove, see GarbageCollector
+
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (report *GCReport) addError(message string, err error) {
+	if len(report.Errors) < gcReportLimit {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", message, err))
+	}
+}
+
+// GarbageCollector removes orphaned files, unreferenced chunks and content no chunk points at once older than the grace period
+type GarbageCollector struct {
+	fileService  *FileService
+	logger       *zap.Logger
+	interval     time.Duration
+	grace        time.Duration
+	dryRun       bool
+	sweepStorage bool //also checks every blob in storage, only safe when the backend is dedicated to the vault
+
+	mu           sync.Mutex
+	running      bool
+	lastReport   *GCReport
+	pendingBlobs map[string]time.Time //unreferenced blobs by when they were first found
+}
+
+func NewGarbageCollector(logger *zap.Logger, fileService *FileService) *GarbageCollector {
+	interval := viper.GetDuration("gc.interval")
+	if interval <= 0 {
+		interval = DefaultGCInterval
+	}
+	grace := viper.GetDuration("gc.grace")
+	if grace <= 0 {
+		grace = DefaultGCGrace
+	}
+	dryRun := DefaultGCDryRun
+	if viper.IsSet("gc.dry_run") {
+		dryRun = viper.GetBool("gc.dry_run")
+	}
+
+	return &GarbageCollector{
+		fileService:  fileService,
+		logger:       logger,
+		interval:     interval,
+		grace:        grace,
+		dryRun:       dryRun,
+		sweepStorage: viper.GetBool("gc.sweep_storage"),
+		pendingBlobs: make(map[string]time.Time),
+	}
+}
+
+// Run collects on every interval, with the configured dry run mode, until the context is cancelled
+func (gc *GarbageCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(gc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := gc.Collect(ctx, gc.dryRun)
+			if err != nil {
+				gc.logger.Error("Garbage collection failed", zap.Error(err))
+				continue
+			}
+			gc.logger.Info("Garbage collection finished", zap.Bool("dry_run", report.DryRun),
+				zap.Int("orphaned_files", report.OrphanedFiles), zap.Int("unreferenced_chunks", report.UnreferencedChunks),
+				zap.Int("orphaned_blobs", report.OrphanedBlobs), zap.Int64("reclaimed_bytes", report.ReclaimedBytes),
+				zap.Int("errors", len(report.Errors)))
+		}
+	}
+}
+
+// Collect runs a collection now. A dry run only reports what a run would remove.
+// Fails with ErrGCRunning while another run is in progress.
+func (gc *GarbageCollector) Collect(ctx context.Context, dryRun bool) (GCReport, error) {
+	gc.mu.Lock()
+	if gc.running {
+		gc.mu.Unlock()
+		return GCReport{}, ErrGCRunning
+	}
+	gc.running = true
+	gc.mu.Unlock()
+
+	report, err := gc.collect(ctx, time.Now().Add(-gc.grace), dryRun)
+
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.running = false
+	if err != nil {
+		return GCReport{}, err
+	}
+	gc.lastReport = &report
+	return report, nil
+}
+
+// LastReport returns the report of the last completed run, ErrGCNotRun before the first one
+func (gc *GarbageCollector) LastReport() (GCReport, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	if gc.lastReport == nil {
+		return GCReport{}, ErrGCNotRun
+	}
+	return *gc.lastReport, nil
+}
+
+// collect leaves alone files and chunks recorded after the cutoff. Fails only if files, users or chunks cannot be
+// listed, errors on single items are recorded in the report and the run goes on.
+func (gc *GarbageCollector) collect(ctx context.Context, cutoff time.Time, dryRun bool) (GCReport, error) {
+	report := GCReport{DryRun: dryRun, StartedAt: time.Now(), Cutoff: cutoff}
+
+	listedFiles, err := gc.listedFiles(ctx)
+	if err != nil {
+		return GCReport{}, err
+	}
+	heldChunks, err := gc.collectFiles(ctx, &report, cutoff, listedFiles)
+	if err != nil {
+		return GCReport{}, err
+	}
+	if err := gc.collectChunks(ctx, &report, cutoff, heldChunks); err != nil {
+		return GCReport{}, err
+	}
+	if err := gc.collectBlobs(ctx, &report, cutoff); err != nil {
+		return GCReport{}, err
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// listedFiles returns the ids of the files listed by any user
+func (gc *GarbageCollector) listedFiles(ctx context.Context) (map[primitive.ObjectID]bool, error) {
+	listed := make(map[primitive.ObjectID]bool)
+	afterId := primitive.NilObjectID
+	for {
+		users, err := gc.fileService.userService.ListUsers(ctx, afterId, gcPageSize)
+		if err != nil {
+			return nil, apperror.Internal("something went wrong listing users", err)
+		}
+		for _, user := range users {
+			for _, fileId := range user.Files {
+				listed[fileId] = true
+			}
+		}
+		if len(users) < gcPageSize {
+			return listed, nil
+		}
+		afterId = users[len(users)-1].ID
+	}
+}
+
+// collectFiles purges the orphaned files and returns the ids of the chunks the remaining files hold
+func (gc *GarbageCollector) collectFiles(ctx context.Context, report *GCReport, cutoff time.Time, listedFiles map[primitive.ObjectID]bool) (map[primitive.ObjectID]bool, error) {
+	held := make(map[primitive.ObjectID]bool)
+	afterId := primitive.NilObjectID
+	for {
+		files, err := gc.fileService.repo.ListAll(ctx, afterId, gcPageSize)
+		if err != nil {
+			return nil, apperror.Internal("something went wrong listing files", err)
+		}
+		for _, file := range files {
+			if isHeld(file, listedFiles, cutoff) {
+				holdChunks(held, file)
+				continue
+			}
+
+			report.OrphanedFiles++
+			if len(report.OrphanedFileIDs) < gcReportLimit {
+				report.OrphanedFileIDs = append(report.OrphanedFileIDs, file.ID.Hex())
+			}
+			if report.DryRun {
+				holdChunks(held, file)
+				continue
+			}
+			released, err := gc.fileService.purge(ctx, file)
+			if err != nil {
+				report.addError("purging file "+file.ID.Hex(), err)
+				holdChunks(held, file)
+				continue
+			}
+			for _, chunk := range released {
+				report.ReclaimedBytes += chunk.Size
+			}
+			gc.logger.Info("Purged orphaned file", zap.String("file_id", file.ID.Hex()), zap.Int("released_chunks", len(released)))
+		}
+		if len(files) < gcPageSize {
+			return held, nil
+		}
+		afterId = files[len(files)-1].ID
+	}
+}
+
+// isHeld reports whether a file is kept: a user lists it, it has an owner or a collaborator, or it was recorded after
+// the cutoff. Files uploaded before files carried a creation time count as recorded before any cutoff.
+func isHeld(file data.File, listedFiles map[primitive.ObjectID]bool, cutoff time.Time) bool {
+	return listedFiles[file.ID] || !file.OwnerID.IsZero() || len(file.Collaborators) > 0 || !file.CreatedAt.Before(cutoff)
+}
+
+func holdChunks(held map[primitive.ObjectID]bool, file data.File) {
+	for _, version := range fileVersions(file) {
+		for _, chunkId := range version.ChunkIDs {
+			held[chunkId] = true
+		}
+	}
+}
+
+// collectChunks removes the chunks without references unless a file still holds them, their content is removed by a
+// later run, see collectBlobs
+func (gc *GarbageCollector) collectChunks(ctx context.Context, report *GCReport, cutoff time.Time, heldChunks map[primitive.ObjectID]bool) error {
+	chunkRepo := gc.fileService.chunkService.repo
+	afterId := primitive.NilObjectID
+	for {
+		chunks, err := chunkRepo.ListUnreferenced(ctx, cutoff, afterId, gcPageSize)
+		if err != nil {
+			return apperror.Internal("something went wrong listing unreferenced chunks", err)
+		}
+		for _, chunk := range chunks {
+			if heldChunks[chunk.ID] {
+				//a reference count out of step with the files, removing the chunk would break them
+				gc.logger.Warn("Chunk without references is held by a file", zap.String("chunk_id", chunk.ID.Hex()), zap.String("hash", chunk.Hash))
+				continue
+			}
+			if report.DryRun {
+				report.UnreferencedChunks++
+				report.ReclaimedBytes += chunk.Size
+				continue
+			}
+
+			//released first, content of a chunk deleted and not yet released would be left in storage for good
+			if err := gc.fileService.chunkService.ReleaseContent(ctx, []data.Chunk{chunk}); err != nil {
+				report.addError("releasing the content of chunk "+chunk.ID.Hex(), err)
+				continue
+			}
+			deleted, err := chunkRepo.DeleteUnreferenced(ctx, chunk.ID)
+			if err != nil {
+				report.addError("deleting chunk "+chunk.ID.Hex(), err)
+				continue
+			}
+			if !deleted {
+				//referenced again since it was listed, collectBlobs forgets the released content
+				continue
+			}
+			report.UnreferencedChunks++
+			report.ReclaimedBytes += chunk.Size
+		}
+		if len(chunks) < gcPageSize {
+			return nil
+		}
+		afterId = chunks[len(chunks)-1].ID
+	}
+}
+
+// collectBlobs removes the content no chunk points at that was released or found unreferenced before the cutoff.
+// Checks the released content, the content pending from earlier runs and, with gc.sweep_storage, every blob in
+// storage against the chunks one by one.
+func (gc *GarbageCollector) collectBlobs(ctx context.Context, report *GCReport, cutoff time.Time) error {
+	blobStore := gc.fileService.blobStore
+	released, err := gc.releasedContent(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	candidates := make(map[string]time.Time) //by when first released or found unreferenced
+	if gc.sweepStorage {
+		addresses, err := blobStore.List(ctx)
+		if err != nil {
+			return apperror.StorageUnavailable("something went wrong listing the stored content", err)
+		}
+		for _, address := range addresses {
+			candidates[address] = now
+		}
+	}
+	for _, found := range []map[string]time.Time{gc.pendingBlobs, released} {
+		for address, firstFound := range found {
+			if earlier, ok := candidates[address]; !ok || firstFound.Before(earlier) {
+				candidates[address] = firstFound
+			}
+		}
+	}
+
+	pending := make(map[string]time.Time)
+	for address, firstFound := range candidates {
+		referenced, err := gc.fileService.chunkService.IsReferenced(ctx, address)
+		if err != nil {
+			report.addError("checking blob "+address, err)
+			pending[address] = firstFound
+			continue
+		}
+		if referenced {
+			gc.forgetReleased(ctx, report, released, address)
+			continue
+		}
+
+		if !firstFound.Before(cutoff) {
+			report.PendingBlobs++
+			pending[address] = firstFound
+			continue
+		}
+
+		report.OrphanedBlobs++
+		if len(report.OrphanedBlobAddresses) < gcReportLimit {
+			report.OrphanedBlobAddresses = append(report.OrphanedBlobAddresses, address)
+		}
+		if report.DryRun {
+			pending[address] = firstFound
+			continue
+		}
+		if err := blobStore.Delete(address); err != nil && !errors.Is(err, storage.ErrBlobNotFound) {
+			report.addError("deleting blob "+address, err)
+			pending[address] = firstFound
+			continue
+		}
+		gc.forgetReleased(ctx, report, released, address)
+		gc.logger.Info("Removed orphaned blob", zap.String("address", address))
+	}
+
+	//dry runs remember the blobs they found too, they only remove nothing
+	gc.pendingBlobs = pending
+	return nil
+}
+
+// releasedContent returns the content released by uploads that did not commit, purges and removed chunks, by when
+// it was first released
+func (gc *GarbageCollector) releasedContent(ctx context.Context) (map[string]time.Time, error) {
+	chunkRepo := gc.fileService.chunkService.repo
+	released := make(map[string]time.Time)
+	afterHash := ""
+	for {
+		page, err := chunkRepo.ListReleased(ctx, afterHash, gcPageSize)
+		if err != nil {
+			return nil, apperror.Internal("something went wrong listing released content", err)
+		}
+		for _, content := range page {
+			released[content.Hash] = content.ReleasedAt
+		}
+		if len(page) < gcPageSize {
+			return released, nil
+		}
+		afterHash = page[len(page)-1].Hash
+	}
+}
+
+// forgetReleased drops the record of released content once it is removed or referenced again, dry runs keep it
+func (gc *GarbageCollector) forgetReleased(ctx context.Context, report *GCReport, released map[string]time.Time, address string) {
+	if _, ok := released[address]; !ok || report.DryRun {
+		return
+	}
+	if err := gc.fileService.chunkService.repo.RemoveReleased(ctx, address); err != nil {
+		report.addError("forgetting released content "+address, err)
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestGarbageCollectorRemovesOrphans(t *testing.T) {
+	services := newTestServices(t, map[string]interface{}{
+		"chunking.size":    64,
+		"gc.sweep_storage": true,
+	})
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+	gc := NewGarbageCollector(zap.NewNop(), services.fileService)
+
+	kept := "content of a file its owner lists"
+	if err := services.fileService.CreateFile(ctx, strings.NewReader(kept), "kept.txt", principal, Digests{}); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+
+	//a file no user lists and without an owner, as left behind by an upload that failed halfway before uploads were
+	//transactional
+	orphanHash, err := services.blobStore.Put(strings.NewReader("content of an orphaned file"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	orphanChunk, err := services.chunks.Acquire(ctx, orphanHash, 27, "")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	orphanFile, err := services.files.Add(ctx, data.File{
+		Name:      "orphan.txt",
+		Type:      "txt",
+		Size:      orphanChunk.Size,
+		ChunkIDs:  []primitive.ObjectID{orphanChunk.ID},
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	//a file its owner does not list, uploaded before files carried a creation time
+	other := services.signIn(t, "grace@example.com")
+	ownedFile, err := services.files.Add(ctx, data.File{OwnerID: other.UserID, Name: "legacy.txt", Type: "txt"})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	//content no chunk points at
+	strayHash, err := services.blobStore.Put(strings.NewReader("content of an upload that never committed"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	report, err := gc.collect(ctx, time.Now().Add(-time.Hour), true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if report.OrphanedFiles != 1 || report.OrphanedFileIDs[0] != orphanFile.ID.Hex() || report.PendingBlobs != 1 {
+		t.Fatalf("dry run report = %+v, want the orphaned file and the stray blob pending", report)
+	}
+	if _, err := services.files.Get(ctx, orphanFile.ID); err != nil {
+		t.Fatalf("dry run removed the orphaned file: %v", err)
+	}
+
+	report, err = gc.collect(ctx, time.Now(), false)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if report.OrphanedFiles != 1 || report.OrphanedBlobs != 1 || report.OrphanedBlobAddresses[0] != strayHash || report.PendingBlobs != 1 {
+		t.Fatalf("report = %+v, want the orphaned file and the stray blob removed, the purged content pending", report)
+	}
+	if _, err := services.files.Get(ctx, orphanFile.ID); err == nil {
+		t.Fatal("orphaned file still recorded")
+	}
+	if _, err := services.files.Get(ctx, ownedFile.ID); err != nil {
+		t.Fatalf("file with an owner removed: %v", err)
+	}
+	if exists, _ := services.blobStore.Exists(orphanHash); !exists {
+		t.Fatal("content of the purged file removed before the grace period")
+	}
+
+	//content released by the purge is removed once it stayed unreferenced for the grace period
+	report, err = gc.collect(ctx, time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if report.OrphanedBlobs != 1 || report.OrphanedBlobAddresses[0] != orphanHash {
+		t.Fatalf("report = %+v, want the purged content removed", report)
+	}
+	for _, hash := range []string{orphanHash, strayHash} {
+		if exists, _ := services.blobStore.Exists(hash); exists {
+			t.Fatalf("orphaned content %s left in storage", hash)
+		}
+	}
+
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{})
+	if err != nil || len(page.Files) != 1 {
+		t.Fatalf("ListFiles: %v, %d files", err, len(page.Files))
+	}
+	if got := readFile(t, services, page.Files[0].ID, principal); got != kept {
+		t.Fatalf("listed file reads %q after collection, want %q", got, kept)
+	}
+}
+
+func TestGarbageCollectorRemovesContentOfUploadsThatDidNotCommit(t *testing.T) {
+	services := newTestServices(t, map[string]interface{}{"chunking.size": 64})
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	content := "content of an upload rejected for its digest"
+	err := services.fileService.CreateFile(ctx, strings.NewReader(content), "notes.txt", principal, Digests{SHA256: strings.Repeat("0", 64)})
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("CreateFile: got %v, want ErrDigestMismatch", err)
+	}
+	released, err := services.chunks.ListReleased(ctx, "", 0)
+	if err != nil || len(released) == 0 {
+		t.Fatalf("ListReleased: %v, %d released, want the content of the upload", err, len(released))
+	}
+
+	//a collector started later, the released content is recorded rather than kept in memory
+	gc := NewGarbageCollector(zap.NewNop(), services.fileService)
+	report, err := gc.collect(ctx, time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if report.OrphanedBlobs != len(released) {
+		t.Fatalf("report = %+v, want the %d released blobs removed", report, len(released))
+	}
+	for _, content := range released {
+		if exists, _ := services.blobStore.Exists(content.Hash); exists {
+			t.Fatalf("released content %s left in storage", content.Hash)
+		}
+	}
+	if left, _ := services.chunks.ListReleased(ctx, "", 0); len(left) != 0 {
+		t.Fatalf("%d released content records left after removal", len(left))
+	}
+}
+
+func TestGarbageCollectorRejectsConcurrentRuns(t *testing.T) {
+	services := newTestServices(t, nil)
+	gc := NewGarbageCollector(zap.NewNop(), services.fileService)
+
+	if _, err := gc.LastReport(); err != ErrGCNotRun {
+		t.Fatalf("LastReport before any run: got %v, want ErrGCNotRun", err)
+	}
+
+	gc.running = true
+	if _, err := gc.Collect(context.Background(), true); err != ErrGCRunning {
+		t.Fatalf("Collect during a run: got %v, want ErrGCRunning", err)
+	}
+	gc.running = false
+
+	report, err := gc.Collect(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	last, err := gc.LastReport()
+	if err != nil || !last.StartedAt.Equal(report.StartedAt) {
+		t.Fatalf("LastReport = %+v, %v, want the report of the run", last, err)
+	}
+}
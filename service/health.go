@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultHealthCheckTimeout = 2 * time.Second // used when health.timeout is not configured
+
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// HealthCheck reports whether a dependency is usable, it should give up once the context is done
+type HealthCheck func(ctx context.Context) error
+
+// DependencyStatus is the outcome of one dependency's check. The report is served unauthenticated, why a check
+// failed is only logged.
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// ReadinessReport is up only when every dependency is up
+type ReadinessReport struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// HealthService runs the readiness checks of the service's dependencies
+type HealthService struct {
+	checks  map[string]HealthCheck
+	timeout time.Duration
+	logger  *zap.Logger
+}
+
+func NewHealthService(logger *zap.Logger) *HealthService {
+	timeout := viper.GetDuration("health.timeout")
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	return &HealthService{
+		checks:  map[string]HealthCheck{},
+		timeout: timeout,
+		logger:  logger,
+	}
+}
+
+// Register adds a dependency check under the name it is reported with
+func (service *HealthService) Register(name string, check HealthCheck) {
+	service.checks[name] = check
+}
+
+// Ready runs every check concurrently, each bounded by the configured timeout
+func (service *HealthService) Ready(ctx context.Context) ReadinessReport {
+	report := ReadinessReport{
+		Status:       StatusUp,
+		Dependencies: map[string]DependencyStatus{},
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range service.checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+			status, err := service.run(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Dependencies[name] = status
+			if err != nil {
+				report.Status = StatusDown
+				service.logger.Warn("Dependency is down", zap.String("dependency", name), zap.Error(err))
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (service *HealthService) run(ctx context.Context, check HealthCheck) (DependencyStatus, error) {
+	checkCtx, cancel := context.WithTimeout(ctx, service.timeout)
+	defer cancel()
+
+	started := time.Now()
+	err := check(checkCtx)
+	status := DependencyStatus{
+		Status:    StatusUp,
+		LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = StatusDown
+	}
+	return status, err
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var ErrInvalidListRequest = apperror.Invalid("invalid_list_request", "invalid cursor, limit, sort or order")
+
+// FileMetadata is the client facing description of a file
+type FileMetadata struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Size      int64     `json:"size"`
+	Hash      string    `json:"hash"`
+	MD5       string    `json:"md5,omitempty"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ListFilesRequest struct {
+	Cursor string
+	Limit  int
+	Sort   string //name, size, created or updated (default)
+	Order  string //asc or desc (default)
+	Type   string
+}
+
+type FilePage struct {
+	Files      []FileMetadata `json:"files"`
+	NextCursor string         `json:"next_cursor,omitempty"` //empty on the last page
+}
+
+// listCursor is the position after the last file of a page, opaque to clients
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+var sortFields = map[string]string{
+	"name":    "name",
+	"size":    "size",
+	"created": "created_at",
+	"updated": "updated_at",
+}
+
+// ListFiles returns a page of the user's files, the trash excluded
+func (fs *FileService) ListFiles(ctx context.Context, principal identity.Principal, request ListFilesRequest) (FilePage, error) {
+	if err := requireScope(principal, string(ActionRead)); err != nil {
+		return FilePage{}, err
+	}
+	query, err := buildListQuery(principal.UserID, request)
+	if err != nil {
+		return FilePage{}, err
+	}
+
+	//fetching one extra file tells whether there is a next page
+	files, err := fs.repo.List(ctx, query)
+	if err != nil {
+		return FilePage{}, apperror.Internal("something went wrong listing files", err)
+	}
+
+	page := FilePage{Files: []FileMetadata{}}
+	hasMore := int64(len(files)) > query.Limit-1
+	if hasMore {
+		files = files[:query.Limit-1]
+	}
+	for _, file := range files {
+		page.Files = append(page.Files, ToFileMetadata(file))
+	}
+	if hasMore {
+		page.NextCursor = encodeListCursor(query.SortField, files[len(files)-1])
+	}
+	return page, nil
+}
+
+// GetFileMetadata returns the metadata of a single file
+func (fs *FileService) GetFileMetadata(ctx context.Context, fileId string, principal identity.Principal) (FileMetadata, error) {
+	file, err := fs.GetFile(ctx, fileId, principal)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	return ToFileMetadata(file), nil
+}
+
+func ToFileMetadata(file data.File) FileMetadata {
+	return FileMetadata{
+		ID:        file.ID.Hex(),
+		Name:      file.Name,
+		Type:      file.Type,
+		Size:      file.Size,
+		Hash:      file.Hash,
+		MD5:       file.MD5,
+		Version:   currentVersion(file).Number,
+		CreatedAt: file.CreatedAt,
+		UpdatedAt: file.UpdatedAt,
+	}
+}
+
+func buildListQuery(ownerId primitive.ObjectID, request ListFilesRequest) (data.FileListQuery, error) {
+	if len(request.Sort) == 0 {
+		request.Sort = "updated"
+	}
+	sortField, ok := sortFields[request.Sort]
+	if !ok {
+		return data.FileListQuery{}, ErrInvalidListRequest
+	}
+
+	var descending bool
+	switch request.Order {
+	case "desc", "":
+		descending = true
+	case "asc":
+		descending = false
+	default:
+		return data.FileListQuery{}, ErrInvalidListRequest
+	}
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 0 || limit > MaxPageSize {
+		return data.FileListQuery{}, ErrInvalidListRequest
+	}
+
+	query := data.FileListQuery{
+		OwnerID:    ownerId,
+		Type:       request.Type,
+		SortField:  sortField,
+		Descending: descending,
+		Limit:      int64(limit) + 1,
+	}
+
+	if len(request.Cursor) > 0 {
+		afterValue, afterId, err := decodeListCursor(request.Cursor, sortField)
+		if err != nil {
+			return data.FileListQuery{}, ErrInvalidListRequest
+		}
+		query.AfterValue = afterValue
+		query.AfterID = afterId
+	}
+	return query, nil
+}
+
+func encodeListCursor(sortField string, last data.File) string {
+	var value string
+	switch sortField {
+	case "name":
+		value = last.Name
+	case "size":
+		value = strconv.FormatInt(last.Size, 10)
+	case "created_at":
+		value = last.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		value = last.UpdatedAt.Format(time.RFC3339Nano)
+	}
+
+	cursorJSON, _ := json.Marshal(listCursor{Sort: sortField, Value: value, ID: last.ID.Hex()})
+	return base64.RawURLEncoding.EncodeToString(cursorJSON)
+}
+
+// decodeListCursor restores the typed sort value, a cursor is only valid for the sort it was issued for
+func decodeListCursor(encoded string, sortField string) (interface{}, primitive.ObjectID, error) {
+	cursorJSON, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, primitive.NilObjectID, err
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(cursorJSON, &cursor); err != nil {
+		return nil, primitive.NilObjectID, err
+	}
+	if cursor.Sort != sortField {
+		return nil, primitive.NilObjectID, ErrInvalidListRequest.WithMessage("cursor was issued for a different sort")
+	}
+	afterId, err := primitive.ObjectIDFromHex(cursor.ID)
+	if err != nil {
+		return nil, primitive.NilObjectID, err
+	}
+
+	switch sortField {
+	case "size":
+		size, err := strconv.ParseInt(cursor.Value, 10, 64)
+		return size, afterId, err
+	case "created_at", "updated_at":
+		timestamp, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		return timestamp, afterId, err
+	default:
+		return cursor.Value, afterId, nil
+	}
+}
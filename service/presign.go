@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/auth"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidPresignRequest = apperror.Invalid("invalid_presign_request", "invalid operation, expiry or byte range")
+
+// PresignService mints short lived URLs that let browsers and CDNs download or upload a file without the
+// caller's bearer token
+type PresignService struct {
+	signer      *auth.URLSigner
+	fileService *FileService
+	publicURL   string
+	logger      *zap.Logger
+}
+
+func NewPresignService(logger *zap.Logger, signer *auth.URLSigner, fileService *FileService) *PresignService {
+	return &PresignService{
+		signer:      signer,
+		fileService: fileService,
+		publicURL:   strings.TrimSuffix(viper.GetString("server.public_url"), "/"),
+		logger:      logger,
+	}
+}
+
+// PresignedURL is relative to the server when server.public_url is not configured
+type PresignedURL struct {
+	URL       string    `json:"url"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PresignURL returns a URL for the operation on the file, valid for expiresIn, 0 being the default expiry.
+// Downloads can be limited to a byte range. The principal needs the permission the operation itself requires.
+func (service *PresignService) PresignURL(ctx context.Context, fileId string, operation string, expiresIn time.Duration, byteRange *auth.ByteRange, principal identity.Principal) (PresignedURL, error) {
+	if expiresIn == 0 {
+		expiresIn = auth.DefaultSignedURLExpiry
+	}
+	if expiresIn < 0 || expiresIn > service.signer.MaxExpiry() {
+		return PresignedURL{}, ErrInvalidPresignRequest
+	}
+
+	var action Action
+	var method string
+	switch operation {
+	case auth.OperationDownload:
+		action, method = ActionRead, "GET"
+	case auth.OperationUpload:
+		if byteRange != nil {
+			return PresignedURL{}, ErrInvalidPresignRequest
+		}
+		action, method = ActionWrite, "PUT"
+	default:
+		return PresignedURL{}, ErrInvalidPresignRequest
+	}
+
+	file, err := service.fileService.getFile(ctx, fileId, principal, action)
+	if err != nil {
+		return PresignedURL{}, err
+	}
+
+	grant := auth.SignedURL{
+		FileID:    file.ID.Hex(),
+		Operation: operation,
+		Email:     principal.Email,
+		ExpiresAt: time.Now().Add(expiresIn).Truncate(time.Second),
+		Range:     byteRange,
+	}
+	values := service.signer.Sign(grant)
+
+	service.logger.Info("Presigned url", zap.String("file_id", fileId), zap.String("operation", operation),
+		zap.String("user_email", principal.Email), zap.Time("expires_at", grant.ExpiresAt))
+	return PresignedURL{
+		URL:       service.publicURL + "/signed/" + operation + "?" + values.Encode(),
+		Method:    method,
+		ExpiresAt: grant.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/auth"
+	"go.uber.org/zap"
+)
+
+func TestPresignURLKeepsFirstByteRange(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	if err := services.fileService.CreateFile(ctx, strings.NewReader("ranged content"), "ranged.txt", principal, Digests{}); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{})
+	if err != nil || len(page.Files) != 1 {
+		t.Fatalf("ListFiles: %v, %d files", err, len(page.Files))
+	}
+
+	signer, err := auth.NewURLSigner(map[string][]byte{"test": []byte(strings.Repeat("k", 32))}, "test", time.Hour)
+	if err != nil {
+		t.Fatalf("NewURLSigner: %v", err)
+	}
+	presignService := NewPresignService(zap.NewNop(), signer, services.fileService)
+
+	for _, byteRange := range []*auth.ByteRange{nil, {Start: 0, End: 0}} {
+		presigned, err := presignService.PresignURL(ctx, page.Files[0].ID, auth.OperationDownload, 0, byteRange, principal)
+		if err != nil {
+			t.Fatalf("PresignURL: %v", err)
+		}
+		signedURL, err := url.Parse(presigned.URL)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", presigned.URL, err)
+		}
+		grant, err := signer.Verify(signedURL.Query(), time.Now())
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if (byteRange == nil) != (grant.Range == nil) || (byteRange != nil && *grant.Range != *byteRange) {
+			t.Fatalf("signed range %v, want %v", grant.Range, byteRange)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+var ErrQuotaExceeded = apperror.New(apperror.KindQuotaExceeded, "quota_exceeded", "storage quota exceeded")
+
+// QuotaBytes returns the storage quota of the user, 0 meaning unlimited
+func (fs *FileService) QuotaBytes(user data.User) int64 {
+	if user.QuotaBytes > 0 {
+		return user.QuotaBytes
+	}
+	return fs.defaultQuota
+}
+
+// Usage returns the bytes held by the files the user owns, every version and the trash included
+func (fs *FileService) Usage(ctx context.Context, ownerId primitive.ObjectID) (int64, error) {
+	usage, err := fs.repo.UsageByOwner(ctx, ownerId)
+	if err != nil {
+		return 0, apperror.Internal("something went wrong getting the storage usage", err)
+	}
+	return usage, nil
+}
+
+// DedupSavings returns the bytes deduplication saves on the owner's files: the usage minus the size of the distinct
+// chunks the files hold. Content shared with other users' files is not counted, see DedupStats for the global figure.
+func (fs *FileService) DedupSavings(ctx context.Context, ownerId primitive.ObjectID) (int64, error) {
+	usage, err := fs.Usage(ctx, ownerId)
+	if err != nil {
+		return 0, err
+	}
+	chunkIds, err := fs.repo.ChunkIDsByOwner(ctx, ownerId)
+	if err != nil {
+		return 0, apperror.Internal("something went wrong getting the deduplication savings", err)
+	}
+	if len(chunkIds) == 0 {
+		return 0, nil
+	}
+	chunks, err := fs.chunkService.GetChunks(ctx, chunkIds)
+	if err != nil {
+		fs.logger.Error("Failed to resolve chunks of owner", zap.Any("owner_id", ownerId), zap.Error(err))
+		return 0, apperror.Internal("something went wrong getting the deduplication savings", err)
+	}
+
+	var stored int64
+	for _, chunk := range chunks {
+		stored += chunk.Size
+	}
+	return usage - stored, nil
+}
+
+// DedupStats returns the bytes stored against the bytes every file version references, across all users
+func (fs *FileService) DedupStats(ctx context.Context) (data.ChunkStats, error) {
+	stats, err := fs.chunkService.Stats(ctx)
+	if err != nil {
+		return data.ChunkStats{}, apperror.Internal("something went wrong getting the deduplication stats", err)
+	}
+	return stats, nil
+}
+
+// checkQuota fails with ErrQuotaExceeded if adding size bytes takes the owner over its quota.
+// Uploads by collaborators count against the owner of the file.
+func (fs *FileService) checkQuota(ctx context.Context, ownerId primitive.ObjectID, size int64) error {
+	//an owner without a user document gets the default quota
+	owner, err := fs.userService.GetUserByID(ctx, ownerId)
+	if err != nil && !errors.Is(err, data.ErrNotFound) {
+		fs.logger.Error("Failed to get file owner for the quota check", zap.Any("owner_id", ownerId), zap.Error(err))
+		return apperror.Internal("something went wrong checking the storage quota", err)
+	}
+
+	quota := fs.QuotaBytes(owner)
+	if quota <= 0 {
+		return nil
+	}
+
+	usage, err := fs.Usage(ctx, ownerId)
+	if err != nil {
+		return err
+	}
+	if usage+size > quota {
+		fs.logger.Error("Upload exceeds the owner's quota", zap.Any("owner_id", ownerId),
+			zap.Int64("usage", usage), zap.Int64("size", size), zap.Int64("quota", quota))
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// fileOwner returns the id quota is charged to for the file, files uploaded before files carried an owner
+// can only be changed by their owner
+func fileOwner(file data.File, principal identity.Principal) primitive.ObjectID {
+	if file.OwnerID.IsZero() {
+		return principal.UserID
+	}
+	return file.OwnerID
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/storage"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultScrubInterval      = time.Hour          // used when scrub.interval is not configured
+	DefaultScrubReverifyAfter = 7 * 24 * time.Hour // used when scrub.reverify_after is not configured
+	DefaultScrubBatchSize     = 100                // used when scrub.batch_size is not configured
+	DefaultScrubRateBytes     = 4 * 1024 * 1024    // used when scrub.rate_bytes is not configured, per second
+	DefaultScrubChunkTimeout  = 5 * time.Minute    // used when scrub.chunk_timeout is not configured
+	scrubReportLimit          = 100                // alerts listed in a report, the counts cover everything
+)
+
+var ErrScrubNotRun = apperror.NotFound("scrub_not_run", "scrubbing has not run yet")
+
+// ScrubAlert is raised for every chunk found missing or corrupt. It is logged and, with scrub.alert_webhook, posted
+// as JSON to the webhook.
+type ScrubAlert struct {
+	ChunkID        string    `json:"chunk_id"`
+	Hash           string    `json:"hash"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"` //empty if the chunk was never scrubbed before
+	DetectedAt     time.Time `json:"detected_at"`
+}
+
+// ScrubReport sums up a pass over the chunks due for verification
+type ScrubReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	Verified  int   `json:"verified"`
+	OK        int   `json:"ok"`
+	Missing   int   `json:"missing"`
+	Corrupt   int   `json:"corrupt"`
+	Failed    int   `json:"failed"` //could not be checked, the storage backend failed; checked again on the next pass
+	BytesRead int64 `json:"bytes_read"`
+
+	Alerts []ScrubAlert `json:"alerts,omitempty"`
+}
+
+// Scrubber periodically re-fetches the content of every chunk from the storage backend and checks it against the
+// SHA-256 digest recorded for the chunk. The result is recorded on the chunk, missing and corrupt content raises a
+// ScrubAlert. A pass covers the chunks not verified within the reverify window, in batches, and reads at most rate
+// bytes per second so the backend keeps serving downloads. A chunk that cannot be read within the chunk timeout is
+// counted as failed. A chunk recorded before digests were kept has no digest to check, its first scrub only proves
+// the content is retrievable and records the digest later scrubs check against.
+type Scrubber struct {
+	chunkService  *ChunkService
+	blobStore     storage.BlobStore
+	logger        *zap.Logger
+	interval      time.Duration
+	reverifyAfter time.Duration
+	batchSize     int64
+	rateBytes     int64
+	chunkTimeout  time.Duration
+	alertWebhook  string //empty if alerts are only logged
+	client        *http.Client
+
+	mu         sync.Mutex
+	lastReport *ScrubReport
+}
+
+func NewScrubber(logger *zap.Logger, chunkService *ChunkService, blobStore storage.BlobStore) *Scrubber {
+	interval := viper.GetDuration("scrub.interval")
+	if interval <= 0 {
+		interval = DefaultScrubInterval
+	}
+	reverifyAfter := viper.GetDuration("scrub.reverify_after")
+	if reverifyAfter <= 0 {
+		reverifyAfter = DefaultScrubReverifyAfter
+	}
+	batchSize := viper.GetInt64("scrub.batch_size")
+	if batchSize <= 0 {
+		batchSize = DefaultScrubBatchSize
+	}
+	rateBytes := viper.GetInt64("scrub.rate_bytes")
+	if rateBytes <= 0 {
+		rateBytes = DefaultScrubRateBytes
+	}
+	chunkTimeout := viper.GetDuration("scrub.chunk_timeout")
+	if chunkTimeout <= 0 {
+		chunkTimeout = DefaultScrubChunkTimeout
+	}
+
+	return &Scrubber{
+		chunkService:  chunkService,
+		blobStore:     blobStore,
+		logger:        logger,
+		interval:      interval,
+		reverifyAfter: reverifyAfter,
+		batchSize:     batchSize,
+		rateBytes:     rateBytes,
+		chunkTimeout:  chunkTimeout,
+		alertWebhook:  viper.GetString("scrub.alert_webhook"),
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run scrubs on every interval until the context is cancelled
+func (scrubber *Scrubber) Run(ctx context.Context) {
+	ticker := time.NewTicker(scrubber.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := scrubber.Scrub(ctx)
+			if err != nil {
+				scrubber.logger.Error("Scrubbing failed", zap.Error(err))
+				continue
+			}
+			if report.Verified > 0 {
+				scrubber.logger.Info("Scrubbing finished", zap.Int("verified", report.Verified), zap.Int("missing", report.Missing),
+					zap.Int("corrupt", report.Corrupt), zap.Int("failed", report.Failed), zap.Int64("bytes_read", report.BytesRead))
+			}
+		}
+	}
+}
+
+// LastReport returns the report of the last completed pass, ErrScrubNotRun before the first one
+func (scrubber *Scrubber) LastReport() (ScrubReport, error) {
+	scrubber.mu.Lock()
+	defer scrubber.mu.Unlock()
+
+	if scrubber.lastReport == nil {
+		return ScrubReport{}, ErrScrubNotRun
+	}
+	return *scrubber.lastReport, nil
+}
+
+// Scrub runs a pass now over the chunks not verified within the reverify window. Fails only if chunks cannot be
+// listed or the context is cancelled, a chunk that cannot be checked is counted as failed and the pass goes on.
+func (scrubber *Scrubber) Scrub(ctx context.Context) (ScrubReport, error) {
+	report := ScrubReport{StartedAt: time.Now()}
+	verifiedBefore := report.StartedAt.Add(-scrubber.reverifyAfter)
+	limiter := newRateLimiter(scrubber.rateBytes)
+
+	afterId := primitive.NilObjectID
+	for {
+		chunks, err := scrubber.chunkService.repo.ListUnverified(ctx, verifiedBefore, afterId, scrubber.batchSize)
+		if err != nil {
+			return ScrubReport{}, apperror.Internal("something went wrong listing chunks to scrub", err)
+		}
+		for _, chunk := range chunks {
+			if err := ctx.Err(); err != nil {
+				return ScrubReport{}, err
+			}
+			scrubber.scrubChunk(ctx, &report, limiter, chunk)
+		}
+		if int64(len(chunks)) < scrubber.batchSize {
+			break
+		}
+		afterId = chunks[len(chunks)-1].ID
+	}
+
+	report.FinishedAt = time.Now()
+	scrubber.mu.Lock()
+	scrubber.lastReport = &report
+	scrubber.mu.Unlock()
+	return report, nil
+}
+
+func (scrubber *Scrubber) scrubChunk(ctx context.Context, report *ScrubReport, limiter *rateLimiter, chunk data.Chunk) {
+	status, digest, bytesRead, err := scrubber.verify(ctx, limiter, chunk)
+	report.BytesRead += bytesRead
+	if err != nil {
+		report.Failed++
+		scrubber.logger.Warn("Failed to scrub chunk", zap.String("chunk_id", chunk.ID.Hex()), zap.String("hash", chunk.Hash), zap.Error(err))
+		return
+	}
+
+	if len(chunk.SHA256) > 0 || status != data.ChunkStatusOK {
+		//only a chunk without a digest gets the one of its retrievable content as a baseline
+		digest = ""
+	}
+	verifiedAt := time.Now()
+	if err := scrubber.chunkService.repo.SetVerification(ctx, chunk.ID, status, verifiedAt, digest); err != nil {
+		report.Failed++
+		scrubber.logger.Warn("Failed to record chunk verification", zap.String("chunk_id", chunk.ID.Hex()), zap.String("status", status), zap.Error(err))
+		return
+	}
+	report.Verified++
+
+	switch status {
+	case data.ChunkStatusOK:
+		report.OK++
+		if len(chunk.Status) > 0 && chunk.Status != data.ChunkStatusOK {
+			scrubber.logger.Info("Chunk content recovered", zap.String("chunk_id", chunk.ID.Hex()), zap.String("previous_status", chunk.Status))
+		}
+		return
+	case data.ChunkStatusMissing:
+		report.Missing++
+	case data.ChunkStatusCorrupt:
+		report.Corrupt++
+	}
+
+	alert := ScrubAlert{
+		ChunkID:        chunk.ID.Hex(),
+		Hash:           chunk.Hash,
+		Status:         status,
+		PreviousStatus: chunk.Status,
+		DetectedAt:     verifiedAt,
+	}
+	if len(report.Alerts) < scrubReportLimit {
+		report.Alerts = append(report.Alerts, alert)
+	}
+	scrubber.raise(ctx, alert)
+}
+
+// verify re-fetches the content of the chunk within the chunk timeout and checks it against the chunk's digest.
+// Returns the status to record and the digest of the content read, or an error if the storage backend failed or
+// timed out and nothing can be said about the content.
+func (scrubber *Scrubber) verify(ctx context.Context, limiter *rateLimiter, chunk data.Chunk) (string, string, int64, error) {
+	exists, err := scrubber.blobStore.Exists(chunk.Hash)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if !exists {
+		return data.ChunkStatusMissing, "", 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, scrubber.chunkTimeout)
+	defer cancel()
+
+	reader, err := scrubber.blobStore.Get(ctx, chunk.Hash)
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		return data.ChunkStatusMissing, "", 0, nil
+	}
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer reader.Close()
+
+	content := &rateLimitedReader{ctx: ctx, reader: reader, limiter: limiter}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, content); err != nil {
+		return "", "", content.read, err
+	}
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if len(chunk.SHA256) > 0 && digest != chunk.SHA256 {
+		return data.ChunkStatusCorrupt, digest, content.read, nil
+	}
+	return data.ChunkStatusOK, digest, content.read, nil
+}
+
+func (scrubber *Scrubber) raise(ctx context.Context, alert ScrubAlert) {
+	scrubber.logger.Error("Chunk content failed verification", zap.String("chunk_id", alert.ChunkID), zap.String("hash", alert.Hash),
+		zap.String("status", alert.Status), zap.String("previous_status", alert.PreviousStatus))
+
+	if len(scrubber.alertWebhook) == 0 {
+		return
+	}
+	if err := scrubber.postAlert(ctx, alert); err != nil {
+		scrubber.logger.Warn("Failed to post scrub alert", zap.String("chunk_id", alert.ChunkID), zap.Error(err))
+	}
+}
+
+// postAlert posts the alert to the webhook, which must answer with a 2xx status. Not retried, the alert is logged
+// either way and the chunk's status is recorded.
+func (scrubber *Scrubber) postAlert(ctx context.Context, alert ScrubAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, scrubber.alertWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := scrubber.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// rateLimiter paces reads to rate bytes per second on average since it was created
+type rateLimiter struct {
+	rate     int64
+	start    time.Time
+	consumed int64
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, start: time.Now()}
+}
+
+// wait blocks until n more bytes fit in the rate, or the context is cancelled
+func (limiter *rateLimiter) wait(ctx context.Context, n int) error {
+	limiter.consumed += int64(n)
+	due := limiter.start.Add(time.Duration(float64(limiter.consumed) / float64(limiter.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rateLimiter
+	read    int64
+}
+
+func (rlr *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := rlr.reader.Read(p)
+	rlr.read += int64(n)
+	if waitErr := rlr.limiter.wait(rlr.ctx, n); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestScrubberRecordsMissingAndCorruptContent(t *testing.T) {
+	alerts := make(chan ScrubAlert, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert ScrubAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	services := newTestServices(t, map[string]interface{}{"scrub.alert_webhook": webhook.URL})
+	ctx := context.Background()
+	root := t.TempDir()
+	blobStore, err := storage.NewFileSystemStore(zap.NewNop(), root)
+	if err != nil {
+		t.Fatalf("NewFileSystemStore: %v", err)
+	}
+
+	chunkIds := map[string]primitive.ObjectID{}
+	hashes := map[string]string{}
+	digests := map[string]string{}
+	for _, name := range []string{"intact", "missing", "corrupt", "legacy"} {
+		content := "content that is " + name
+		hash, err := blobStore.Put(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		digest := sha256.Sum256([]byte(content))
+		digests[name] = hex.EncodeToString(digest[:])
+		if name == "legacy" {
+			//recorded before uploads kept a digest of the content
+			digests[name] = ""
+		}
+		chunk, err := services.chunks.Acquire(ctx, hash, int64(len(content)), digests[name])
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		chunkIds[name], hashes[name] = chunk.ID, hash
+	}
+	if err := blobStore.Delete(hashes["missing"]); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	corruptPath := filepath.Join(root, hashes["corrupt"][:2], hashes["corrupt"])
+	if err := os.WriteFile(corruptPath, []byte("flipped bits"), 0o600); err != nil {
+		t.Fatalf("corrupting blob: %v", err)
+	}
+
+	scrubber := NewScrubber(zap.NewNop(), NewChunkService(zap.NewNop(), services.chunks), blobStore)
+
+	report, err := scrubber.Scrub(ctx)
+	if err != nil {
+		t.Fatalf("Scrub: %v", err)
+	}
+	if report.Verified != 4 || report.OK != 2 || report.Missing != 1 || report.Corrupt != 1 || report.Failed != 0 {
+		t.Fatalf("report = %+v, want two chunks ok and one each missing and corrupt", report)
+	}
+	if len(alerts) != 2 || len(report.Alerts) != 2 {
+		t.Fatalf("posted %d alerts, reported %d, want 2", len(alerts), len(report.Alerts))
+	}
+	for i := 0; i < 2; i++ {
+		if alert := <-alerts; alert.Hash != hashes["missing"] && alert.Hash != hashes["corrupt"] {
+			t.Fatalf("posted alert for %s, want only the missing and corrupt content", alert.Hash)
+		}
+	}
+
+	for name, status := range map[string]string{"intact": data.ChunkStatusOK, "missing": data.ChunkStatusMissing, "corrupt": data.ChunkStatusCorrupt, "legacy": data.ChunkStatusOK} {
+		chunks, err := services.chunks.GetMany(ctx, []primitive.ObjectID{chunkIds[name]})
+		if err != nil {
+			t.Fatalf("GetMany: %v", err)
+		}
+		if chunks[0].Status != status || chunks[0].VerifiedAt == nil {
+			t.Fatalf("%s chunk recorded status %q at %v, want %q", name, chunks[0].Status, chunks[0].VerifiedAt, status)
+		}
+	}
+	//the first scrub of a chunk without a digest records the one later scrubs check against
+	legacy, err := services.chunks.GetMany(ctx, []primitive.ObjectID{chunkIds["legacy"]})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if want := sha256.Sum256([]byte("content that is legacy")); legacy[0].SHA256 != hex.EncodeToString(want[:]) {
+		t.Fatalf("legacy chunk recorded digest %q, want the digest of its content", legacy[0].SHA256)
+	}
+
+	//verified chunks are not due again until the reverify window passes
+	report, err = scrubber.Scrub(ctx)
+	if err != nil {
+		t.Fatalf("second Scrub: %v", err)
+	}
+	if report.Verified != 0 {
+		t.Fatalf("second pass verified %d chunks, want none", report.Verified)
+	}
+	last, err := scrubber.LastReport()
+	if err != nil || !last.StartedAt.Equal(report.StartedAt) {
+		t.Fatalf("LastReport = %+v, %v, want the second pass", last, err)
+	}
+}
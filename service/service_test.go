@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/Hitesh-Nagothu/vault-service/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// testServices wires the services over the in-memory repositories and blob store
+type testServices struct {
+	users      *data.MemoryUserRepository
+	files      *data.MemoryFileRepository
+	chunks     *data.MemoryChunkRepository
+	shareLinks *data.MemoryShareLinkRepository
+	apiKeys    *data.MemoryAPIKeyRepository
+	blobStore  *storage.MemoryStore
+
+	userService      *UserService
+	fileService      *FileService
+	shareLinkService *ShareLinkService
+	apiKeyService    *APIKeyService
+}
+
+// newTestServices takes config as viper key values, set for the duration of the test
+func newTestServices(t *testing.T, config map[string]interface{}) *testServices {
+	t.Helper()
+	for key, value := range config {
+		viper.Set(key, value)
+	}
+	t.Cleanup(viper.Reset)
+
+	logger := zap.NewNop()
+	services := &testServices{
+		users:      data.NewMemoryUserRepository(),
+		files:      data.NewMemoryFileRepository(),
+		chunks:     data.NewMemoryChunkRepository(),
+		shareLinks: data.NewMemoryShareLinkRepository(),
+		apiKeys:    data.NewMemoryAPIKeyRepository(),
+		blobStore:  storage.NewMemoryStore(logger),
+	}
+	services.userService = NewUserService(logger, services.users)
+	chunkService := NewChunkService(logger, services.chunks)
+	services.fileService = NewFileService(logger, services.files, data.NewMemoryUnitOfWork(), services.blobStore, chunkService, services.userService)
+	services.shareLinkService = NewShareLinkService(logger, services.shareLinks, services.fileService)
+	services.apiKeyService = NewAPIKeyService(logger, services.apiKeys)
+	return services
+}
+
+// signIn creates the user on first sight like the auth middleware does and returns its principal
+func (services *testServices) signIn(t *testing.T, email string) identity.Principal {
+	t.Helper()
+	user, err := services.userService.GetOrCreateUser(context.Background(), email)
+	if err != nil {
+		t.Fatalf("failed to sign in %s: %v", email, err)
+	}
+	return identity.Principal{UserID: user.ID, Email: user.Email, Roles: user.Roles}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.uber.org/zap"
+)
+
+// Permissions an owner can grant when sharing, each maps onto a file role
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+var (
+	ErrInvalidShare  = apperror.Invalid("invalid_share", "invalid email or permission, permission is one of read, write")
+	ErrShareNotFound = apperror.NotFound("share_not_found", "file is not shared with this email")
+)
+
+// Share describes who a file is shared with, Pending is true until the user signs in for the first time
+type Share struct {
+	Email      string    `json:"email"`
+	Permission string    `json:"permission"`
+	Pending    bool      `json:"pending"`
+	SharedBy   string    `json:"shared_by"`
+	SharedAt   time.Time `json:"shared_at"`
+}
+
+// SharedFile is a file shared with the caller and the permission it was shared with
+type SharedFile struct {
+	FileMetadata
+	Permission string    `json:"permission"`
+	SharedBy   string    `json:"shared_by"`
+	SharedAt   time.Time `json:"shared_at"`
+}
+
+// ShareFile grants the user with the email read or write access to the file, sharing again changes the permission.
+// Users who have not signed in yet get a pending share that applies as soon as they do.
+func (fs *FileService) ShareFile(ctx context.Context, fileId string, email string, permission string, principal identity.Principal) (Share, error) {
+	email, ok := normalizeEmail(email)
+	role, known := permissionRoles[permission]
+	if !ok || !known || strings.EqualFold(email, principal.Email) {
+		return Share{}, ErrInvalidShare
+	}
+
+	file, err := fs.getFile(ctx, fileId, principal, ActionShare)
+	if err != nil {
+		return Share{}, err
+	}
+
+	//the recipient may not have signed in yet, the share stays pending until it does
+	recipient, err := fs.userService.GetUser(ctx, email)
+	if err != nil && !errors.Is(err, data.ErrNotFound) {
+		fs.logger.Error("Failed to get user the file is shared with", zap.String("email", email), zap.Error(err))
+		return Share{}, apperror.Internal("something went wrong sharing the file", err)
+	}
+
+	collaborator := data.Collaborator{
+		Email:    email,
+		Role:     role,
+		SharedBy: principal.Email,
+		SharedAt: time.Now(),
+	}
+	if err == nil {
+		if recipient.ID == file.OwnerID {
+			return Share{}, ErrInvalidShare
+		}
+		collaborator.UserID = recipient.ID
+	}
+
+	if err := fs.repo.SetCollaborator(ctx, file.ID, collaborator); err != nil {
+		fs.logger.Error("Failed to share file", zap.String("file_id", fileId), zap.String("email", email), zap.Error(err))
+		return Share{}, apperror.Internal("something went wrong sharing the file", err)
+	}
+
+	fs.logger.Info("File shared", zap.String("file_id", fileId), zap.String("email", email), zap.String("permission", permission),
+		zap.Bool("pending", collaborator.UserID.IsZero()))
+	return toShare(collaborator), nil
+}
+
+// ListShares returns who the file is shared with, only the owner can see it
+func (fs *FileService) ListShares(ctx context.Context, fileId string, principal identity.Principal) ([]Share, error) {
+	file, err := fs.getFile(ctx, fileId, principal, ActionShare)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := []Share{}
+	for _, collaborator := range file.Collaborators {
+		shares = append(shares, toShare(collaborator))
+	}
+	return shares, nil
+}
+
+// RevokeShare removes the access the user with the email has to the file, pending shares included
+func (fs *FileService) RevokeShare(ctx context.Context, fileId string, email string, principal identity.Principal) error {
+	email, ok := normalizeEmail(email)
+	if !ok {
+		return ErrInvalidShare
+	}
+
+	file, err := fs.getFile(ctx, fileId, principal, ActionShare)
+	if err != nil {
+		return err
+	}
+
+	removed, err := fs.repo.RemoveCollaborator(ctx, file.ID, email)
+	if err != nil {
+		return apperror.Internal("something went wrong revoking the share", err)
+	}
+	if !removed {
+		return ErrShareNotFound
+	}
+
+	fs.logger.Info("File share revoked", zap.String("file_id", fileId), zap.String("email", email))
+	return nil
+}
+
+// ListSharedWithMe returns the files other users shared with the caller, most recently updated first
+func (fs *FileService) ListSharedWithMe(ctx context.Context, principal identity.Principal) ([]SharedFile, error) {
+	if err := requireScope(principal, string(ActionRead)); err != nil {
+		return nil, err
+	}
+	//shares made out before the caller signed in only carry the email
+	if err := fs.repo.ClaimShares(ctx, principal.UserID, principal.Email); err != nil {
+		return nil, apperror.Internal("something went wrong listing shared files", err)
+	}
+
+	files, err := fs.repo.ListSharedWith(ctx, principal.UserID)
+	if err != nil {
+		return nil, apperror.Internal("something went wrong listing shared files", err)
+	}
+
+	sharedFiles := []SharedFile{}
+	for _, file := range files {
+		for _, collaborator := range file.Collaborators {
+			if collaborator.UserID != principal.UserID {
+				continue
+			}
+			sharedFiles = append(sharedFiles, SharedFile{
+				FileMetadata: ToFileMetadata(file),
+				Permission:   rolePermission(collaborator.Role),
+				SharedBy:     collaborator.SharedBy,
+				SharedAt:     collaborator.SharedAt,
+			})
+			break
+		}
+	}
+	return sharedFiles, nil
+}
+
+var permissionRoles = map[string]string{
+	PermissionRead:  RoleViewer,
+	PermissionWrite: RoleEditor,
+}
+
+func rolePermission(role string) string {
+	for permission, permissionRole := range permissionRoles {
+		if permissionRole == role {
+			return permission
+		}
+	}
+	return ""
+}
+
+func toShare(collaborator data.Collaborator) Share {
+	return Share{
+		Email:      collaborator.Email,
+		Permission: rolePermission(collaborator.Role),
+		Pending:    collaborator.UserID.IsZero(),
+		SharedBy:   collaborator.SharedBy,
+		SharedAt:   collaborator.SharedAt,
+	}
+}
+
+// normalizeEmail lowercases the address so shares match the email in the caller's token
+func normalizeEmail(email string) (string, bool) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(address.Address), true
+}
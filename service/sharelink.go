@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	shareLinkPrefix       = "sl_"
+	shareLinkDisplayChars = 10
+)
+
+var (
+	ErrShareLinkNotFound  = apperror.NotFound("share_link_not_found", "share link not found")
+	ErrShareLinkExpired   = apperror.New(apperror.KindGone, "share_link_expired", "share link has expired")
+	ErrShareLinkExhausted = apperror.New(apperror.KindGone, "share_link_exhausted", "share link download limit reached")
+	ErrShareLinkPassword  = apperror.New(apperror.KindUnauthorized, "share_link_password", "share link password is missing or wrong")
+	ErrInvalidShareLink   = apperror.Invalid("invalid_share_link", "invalid share link options")
+)
+
+// ShareLinkService manages public links to files for people without an account
+type ShareLinkService struct {
+	repo        data.ShareLinkRepository
+	fileService *FileService
+	logger      *zap.Logger
+}
+
+func NewShareLinkService(logger *zap.Logger, repo data.ShareLinkRepository, fileService *FileService) *ShareLinkService {
+	return &ShareLinkService{
+		logger:      logger,
+		repo:        repo,
+		fileService: fileService,
+	}
+}
+
+// ShareLinkOptions restrict a link, the zero value never expires, has no password and no download limit
+type ShareLinkOptions struct {
+	ExpiresAt    *time.Time
+	Password     string
+	MaxDownloads int
+}
+
+// ShareLinkInfo describes a link without its token
+type ShareLinkInfo struct {
+	ID                string     `json:"id"`
+	FileID            string     `json:"file_id"`
+	Prefix            string     `json:"prefix"`
+	CreatedBy         string     `json:"created_by"`
+	PasswordProtected bool       `json:"password_protected"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads      int        `json:"max_downloads,omitempty"`
+	Downloads         int        `json:"downloads"`
+	CreatedAt         time.Time  `json:"created_at"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatedShareLink is returned once on creation, it is the only time the token is visible
+type CreatedShareLink struct {
+	ShareLinkInfo
+	Token string `json:"token"`
+}
+
+// CreateLink creates a public link to the file, only principals allowed to share the file can create one
+func (service *ShareLinkService) CreateLink(ctx context.Context, fileId string, principal identity.Principal, linkOptions ShareLinkOptions) (CreatedShareLink, error) {
+	if linkOptions.MaxDownloads < 0 || (linkOptions.ExpiresAt != nil && !linkOptions.ExpiresAt.After(time.Now())) {
+		return CreatedShareLink{}, ErrInvalidShareLink
+	}
+
+	file, err := service.fileService.getFile(ctx, fileId, principal, ActionShare)
+	if err != nil {
+		return CreatedShareLink{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		service.logger.Error("Failed to generate share link token", zap.Error(err))
+		return CreatedShareLink{}, apperror.Internal("something went wrong creating the share link", err)
+	}
+	token := shareLinkPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	link := data.ShareLink{
+		FileID:       file.ID,
+		CreatedBy:    principal.Email,
+		Prefix:       token[:shareLinkDisplayChars],
+		TokenHash:    hashSecret(token),
+		ExpiresAt:    linkOptions.ExpiresAt,
+		MaxDownloads: linkOptions.MaxDownloads,
+		CreatedAt:    time.Now(),
+	}
+	if len(linkOptions.Password) > 0 {
+		//unlike tokens, passwords are picked by people and need a slow hash
+		passwordHash, err := bcrypt.GenerateFromPassword([]byte(linkOptions.Password), bcrypt.DefaultCost)
+		if err != nil {
+			service.logger.Error("Failed to hash share link password", zap.Error(err))
+			return CreatedShareLink{}, ErrInvalidShareLink
+		}
+		link.PasswordHash = string(passwordHash)
+	}
+
+	created, err := service.repo.Add(ctx, link)
+	if err != nil {
+		return CreatedShareLink{}, apperror.Internal("something went wrong creating the share link", err)
+	}
+
+	return CreatedShareLink{ShareLinkInfo: toShareLinkInfo(created), Token: token}, nil
+}
+
+func (service *ShareLinkService) ListLinks(ctx context.Context, fileId string, principal identity.Principal) ([]ShareLinkInfo, error) {
+	file, err := service.fileService.getFile(ctx, fileId, principal, ActionShare)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := service.repo.ListByFile(ctx, file.ID)
+	if err != nil {
+		return nil, apperror.Internal("something went wrong listing share links", err)
+	}
+
+	infos := []ShareLinkInfo{}
+	for _, link := range links {
+		infos = append(infos, toShareLinkInfo(link))
+	}
+	return infos, nil
+}
+
+func (service *ShareLinkService) RevokeLink(ctx context.Context, fileId string, linkId string, principal identity.Principal) error {
+	linkDocumentId, err := primitive.ObjectIDFromHex(linkId)
+	if err != nil {
+		return ErrShareLinkNotFound
+	}
+
+	//revoking stays possible while the file is in the trash
+	file, err := service.fileService.getAuthorizedFile(ctx, fileId, principal, ActionShare)
+	if err != nil {
+		return err
+	}
+
+	err = service.repo.Revoke(ctx, linkDocumentId, file.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrShareLinkNotFound) {
+			return ErrShareLinkNotFound
+		}
+		return apperror.Internal("something went wrong revoking the share link", err)
+	}
+	service.logger.Info("Revoked share link", zap.String("link_id", linkId), zap.String("file_id", fileId))
+	return nil
+}
+
+// OpenLink checks the token and password and counts the download against the link's limit, then returns the file
+// and a reader streaming its current version. Links to files in the trash stop working until the file is restored.
+func (service *ShareLinkService) OpenLink(ctx context.Context, token string, password string) (data.File, io.ReadCloser, error) {
+	if !strings.HasPrefix(token, shareLinkPrefix) {
+		return data.File{}, nil, ErrShareLinkNotFound
+	}
+
+	link, err := service.repo.GetByHash(ctx, hashSecret(token))
+	if errors.Is(err, data.ErrShareLinkNotFound) {
+		return data.File{}, nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return data.File{}, nil, apperror.Internal("something went wrong opening the share link", err)
+	}
+	if link.RevokedAt != nil {
+		return data.File{}, nil, ErrShareLinkNotFound
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return data.File{}, nil, ErrShareLinkExpired
+	}
+	if len(link.PasswordHash) > 0 {
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			service.logger.Error("Wrong share link password", zap.Any("link_id", link.ID))
+			return data.File{}, nil, ErrShareLinkPassword
+		}
+	}
+
+	file, err := service.fileService.repo.Get(ctx, link.FileID)
+	if errors.Is(err, data.ErrNotFound) {
+		return data.File{}, nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return data.File{}, nil, apperror.Internal("something went wrong opening the share link", err)
+	}
+	if file.DeletedAt != nil {
+		return data.File{}, nil, ErrShareLinkNotFound
+	}
+
+	//counted before streaming, a download that fails midway still uses up one of the allowed downloads
+	if err := service.repo.ClaimDownload(ctx, link.ID); err != nil {
+		if errors.Is(err, data.ErrShareLinkExhausted) {
+			return data.File{}, nil, ErrShareLinkExhausted
+		}
+		return data.File{}, nil, apperror.Internal("something went wrong opening the share link", err)
+	}
+
+	content, err := service.fileService.OpenFile(ctx, file)
+	if err != nil {
+		return data.File{}, nil, err
+	}
+	service.logger.Info("Share link download", zap.Any("link_id", link.ID), zap.Any("file_id", file.ID))
+	return file, content, nil
+}
+
+func toShareLinkInfo(link data.ShareLink) ShareLinkInfo {
+	return ShareLinkInfo{
+		ID:                link.ID.Hex(),
+		FileID:            link.FileID.Hex(),
+		Prefix:            link.Prefix,
+		CreatedBy:         link.CreatedBy,
+		PasswordProtected: len(link.PasswordHash) > 0,
+		ExpiresAt:         link.ExpiresAt,
+		MaxDownloads:      link.MaxDownloads,
+		Downloads:         link.Downloads,
+		CreatedAt:         link.CreatedAt,
+		RevokedAt:         link.RevokedAt,
+	}
+}
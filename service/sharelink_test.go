@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+)
+
+// createSharedFile uploads a file for the principal and returns its id
+func createSharedFile(t *testing.T, services *testServices, principal identity.Principal, content string) string {
+	t.Helper()
+	ctx := context.Background()
+	if err := services.fileService.CreateFile(ctx, strings.NewReader(content), "shared.txt", principal, Digests{}); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+	page, err := services.fileService.ListFiles(ctx, principal, ListFilesRequest{})
+	if err != nil || len(page.Files) != 1 {
+		t.Fatalf("ListFiles: %v, %d files", err, len(page.Files))
+	}
+	return page.Files[0].ID
+}
+
+func openLink(services *testServices, token string, password string) (string, error) {
+	_, reader, err := services.shareLinkService.OpenLink(context.Background(), token, password)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	return string(content), err
+}
+
+func TestShareLinkPasswordAndDownloadLimit(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	principal := services.signIn(t, "ada@example.com")
+	fileId := createSharedFile(t, services, principal, "shared content")
+
+	created, err := services.shareLinkService.CreateLink(context.Background(), fileId, principal, ShareLinkOptions{Password: "hunter22", MaxDownloads: 2})
+	if err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	if !created.PasswordProtected || created.MaxDownloads != 2 {
+		t.Fatalf("created link %+v, want password protected with 2 downloads", created.ShareLinkInfo)
+	}
+
+	for _, password := range []string{"", "wrong"} {
+		if _, err := openLink(services, created.Token, password); !errors.Is(err, ErrShareLinkPassword) {
+			t.Fatalf("OpenLink with password %q: got %v, want ErrShareLinkPassword", password, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if content, err := openLink(services, created.Token, "hunter22"); err != nil || content != "shared content" {
+			t.Fatalf("download %d: %q, %v", i+1, content, err)
+		}
+	}
+	if _, err := openLink(services, created.Token, "hunter22"); !errors.Is(err, ErrShareLinkExhausted) {
+		t.Fatalf("download past the limit: got %v, want ErrShareLinkExhausted", err)
+	}
+}
+
+func TestShareLinkExpiryAndRevocation(t *testing.T) {
+	services := newTestServices(t, testConfig)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+	fileId := createSharedFile(t, services, principal, "shared content")
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := services.shareLinkService.CreateLink(ctx, fileId, principal, ShareLinkOptions{ExpiresAt: &past}); !errors.Is(err, ErrInvalidShareLink) {
+		t.Fatalf("CreateLink expiring in the past: got %v, want ErrInvalidShareLink", err)
+	}
+
+	//a link that was valid when created and has expired since
+	token := shareLinkPrefix + "expired-link-token"
+	_, err := services.shareLinks.Add(ctx, data.ShareLink{
+		FileID:    mustObjectId(t, fileId),
+		TokenHash: hashSecret(token),
+		ExpiresAt: &past,
+		CreatedAt: past.Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := openLink(services, token, ""); !errors.Is(err, ErrShareLinkExpired) {
+		t.Fatalf("OpenLink of an expired link: got %v, want ErrShareLinkExpired", err)
+	}
+
+	created, err := services.shareLinkService.CreateLink(ctx, fileId, principal, ShareLinkOptions{})
+	if err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	if err := services.shareLinkService.RevokeLink(ctx, fileId, created.ID, principal); err != nil {
+		t.Fatalf("RevokeLink: %v", err)
+	}
+	for _, token := range []string{created.Token, shareLinkPrefix + "unknown"} {
+		if _, err := openLink(services, token, ""); !errors.Is(err, ErrShareLinkNotFound) {
+			t.Fatalf("OpenLink of %s: got %v, want ErrShareLinkNotFound", token, err)
+		}
+	}
+}
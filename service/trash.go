@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultTrashRetention     = 30 * 24 * time.Hour // used when trash.retention is not configured
+	DefaultTrashPurgeInterval = time.Hour           // used when trash.purge_interval is not configured
+)
+
+// DeleteFile moves the file to the owner's trash, it stays restorable until purged
+func (fs *FileService) DeleteFile(ctx context.Context, fileId string, principal identity.Principal) error {
+	file, err := fs.getFile(ctx, fileId, principal, ActionDelete)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.repo.SoftDelete(ctx, file.ID); err != nil {
+		fs.logger.Error("Failed to move file to trash", zap.String("file_id", fileId), zap.Error(err))
+		return apperror.Internal("something went wrong deleting the file", err)
+	}
+
+	fs.logger.Info("File moved to trash", zap.String("file_id", fileId))
+	return nil
+}
+
+// ListTrash returns the files in the user's trash
+func (fs *FileService) ListTrash(ctx context.Context, principal identity.Principal) ([]data.File, error) {
+	if err := requireScope(principal, string(ActionRead)); err != nil {
+		return nil, err
+	}
+	user, err := fs.userService.GetUser(ctx, principal.Email)
+	if errors.Is(err, data.ErrNotFound) {
+		return []data.File{}, nil
+	}
+	if err != nil {
+		fs.logger.Error("Failed to get user listing the trash", zap.String("user_email", principal.Email), zap.Error(err))
+		return nil, apperror.Internal("something went wrong listing the trash", err)
+	}
+	if len(user.Files) == 0 {
+		return []data.File{}, nil
+	}
+
+	files, err := fs.repo.GetDeleted(ctx, user.Files)
+	if err != nil {
+		return nil, apperror.Internal("something went wrong listing the trash", err)
+	}
+	return files, nil
+}
+
+// RestoreFile takes the file back out of the user's trash
+func (fs *FileService) RestoreFile(ctx context.Context, fileId string, principal identity.Principal) error {
+	file, err := fs.getTrashedFile(ctx, fileId, principal)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.repo.Restore(ctx, file.ID); err != nil {
+		fs.logger.Error("Failed to restore file from trash", zap.String("file_id", fileId), zap.Error(err))
+		return apperror.Internal("something went wrong restoring the file", err)
+	}
+
+	fs.logger.Info("File restored from trash", zap.String("file_id", fileId))
+	return nil
+}
+
+// PurgeFile permanently deletes a file from the user's trash
+func (fs *FileService) PurgeFile(ctx context.Context, fileId string, principal identity.Principal) error {
+	file, err := fs.getTrashedFile(ctx, fileId, principal)
+	if err != nil {
+		return err
+	}
+	_, err = fs.purge(ctx, file)
+	return err
+}
+
+// PurgeExpired permanently deletes every file that has been in the trash since before the cutoff.
+// Returns the number of files purged.
+func (fs *FileService) PurgeExpired(ctx context.Context, cutoff time.Time) (int, error) {
+	files, err := fs.repo.GetDeletedBefore(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, file := range files {
+		if _, err := fs.purge(ctx, file); err != nil {
+			//keep going, the file is picked up again on the next run
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (fs *FileService) getTrashedFile(ctx context.Context, fileId string, principal identity.Principal) (data.File, error) {
+	file, err := fs.getAuthorizedFile(ctx, fileId, principal, ActionDelete)
+	if err != nil {
+		return data.File{}, err
+	}
+	if file.DeletedAt == nil {
+		return data.File{}, ErrFileNotFound
+	}
+	return file, nil
+}
+
+// purge removes the file and every user's reference to it, releases the chunks of every version and hands the content
+// of the chunks nothing references anymore to the garbage collector, in one transaction. Returns the released chunks.
+func (fs *FileService) purge(ctx context.Context, file data.File) ([]data.Chunk, error) {
+	//a chunk is released once for each time a version holds it, rollbacks included
+	chunkIds := []primitive.ObjectID{}
+	for _, version := range fileVersions(file) {
+		chunkIds = append(chunkIds, version.ChunkIDs...)
+	}
+
+	var released []data.Chunk
+	txErr := fs.unitOfWork.Do(ctx, func(txCtx context.Context) error {
+		chunks, err := fs.chunkService.ReleaseChunks(txCtx, chunkIds)
+		if err != nil {
+			return err
+		}
+		if err := fs.repo.Delete(txCtx, file.ID); err != nil {
+			return err
+		}
+		if err := fs.userService.RemoveFile(txCtx, file.ID); err != nil {
+			return err
+		}
+		if err := fs.chunkService.ReleaseContent(txCtx, chunks); err != nil {
+			return err
+		}
+		released = chunks
+		return nil
+	})
+	if txErr != nil {
+		fs.logger.Error("Failed to purge file", zap.String("file_id", file.ID.Hex()), zap.Error(txErr))
+		return nil, apperror.Internal("something went wrong purging the file", txErr)
+	}
+
+	fs.logger.Info("File purged", zap.String("file_id", file.ID.Hex()), zap.Int("released_chunks", len(released)))
+	return released, nil
+}
+
+// TrashPurger periodically purges files that outlived the trash retention window
+type TrashPurger struct {
+	fileService *FileService
+	logger      *zap.Logger
+	retention   time.Duration
+	interval    time.Duration
+}
+
+func NewTrashPurger(logger *zap.Logger, fileService *FileService) *TrashPurger {
+	retention := viper.GetDuration("trash.retention")
+	if retention <= 0 {
+		retention = DefaultTrashRetention
+	}
+	interval := viper.GetDuration("trash.purge_interval")
+	if interval <= 0 {
+		interval = DefaultTrashPurgeInterval
+	}
+
+	return &TrashPurger{
+		fileService: fileService,
+		logger:      logger,
+		retention:   retention,
+		interval:    interval,
+	}
+}
+
+// Run purges on every interval until the context is cancelled
+func (tp *TrashPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(tp.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := time.Now().Add(-tp.retention)
+			purged, err := tp.fileService.PurgeExpired(ctx, cutoff)
+			if err != nil {
+				tp.logger.Error("Failed to purge expired trash", zap.Error(err))
+				continue
+			}
+			if purged > 0 {
+				tp.logger.Info("Purged expired trash", zap.Int("files", purged), zap.Time("cutoff", cutoff))
+			}
+		}
+	}
+}
@@ -1,64 +1,102 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"time"
 
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
 	"github.com/Hitesh-Nagothu/vault-service/data"
-	"github.com/Hitesh-Nagothu/vault-service/utility"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
+var ErrUserExists = apperror.New(apperror.KindConflict, "user_exists", "user with email already exists")
+
 type UserService struct {
-	repo   *data.UserRepository
+	repo   data.UserRepository
 	logger *zap.Logger
 }
 
-func NewUserService(logger *zap.Logger, repo *data.UserRepository) *UserService {
+func NewUserService(logger *zap.Logger, repo data.UserRepository) *UserService {
 	return &UserService{
 		logger: logger,
 		repo:   repo,
 	}
 }
 
-func (service *UserService) CreateUser(email string) (data.User, error) {
-	//check if user with same email already exists
-	existingUser, err := service.GetUser(email)
-	if err != nil {
-		service.logger.Error("Something went wrong creating the user", zap.Error(err))
-		return data.User{}, nil
-	}
-
-	isEmptyUserData := utility.IsStructEmpty(existingUser)
-	if !isEmptyUserData {
-		service.logger.Error("User with email already exists", zap.String("email", email))
-		return data.User{}, errors.New("user with email already exists")
-	}
-
+// CreateUser fails with ErrUserExists if a user with the email already exists
+func (service *UserService) CreateUser(ctx context.Context, email string) (data.User, error) {
 	newUser := data.User{
 		Email:          email,
 		LastAccessedOn: time.Now(),
 		Files:          []primitive.ObjectID{},
 	}
-	createdUser, createErr := service.repo.Add(&newUser)
-	if createErr != nil {
-		service.logger.Error("Failed to create new user", zap.Error(createErr))
-		return data.User{}, nil
+	createdUser, err := service.repo.Add(ctx, &newUser)
+	if errors.Is(err, data.ErrDuplicate) {
+		service.logger.Error("User with email already exists", zap.String("email", email))
+		return data.User{}, ErrUserExists
+	}
+	if err != nil {
+		service.logger.Error("Failed to create new user", zap.String("email", email), zap.Error(err))
+		return data.User{}, err
 	}
 	return createdUser, nil
 }
 
-func (service *UserService) GetUser(email string) (data.User, error) {
+// GetUser fails with data.ErrUserNotFound if no user has the email
+func (service *UserService) GetUser(ctx context.Context, email string) (data.User, error) {
+	return service.repo.Get(ctx, email)
+}
+
+func (service *UserService) UpdateUser(ctx context.Context, userId primitive.ObjectID, userUpdateBody data.User) error {
+	return service.repo.Update(ctx, userId, userUpdateBody)
+}
+
+func (service *UserService) RemoveFile(ctx context.Context, fileId primitive.ObjectID) error {
+	return service.repo.RemoveFile(ctx, fileId)
+}
+
+// GetOrCreateUser returns the user with the email, creating it on first sight
+func (service *UserService) GetOrCreateUser(ctx context.Context, email string) (data.User, error) {
+	user, err := service.GetUser(ctx, email)
+	if !errors.Is(err, data.ErrNotFound) {
+		return user, err
+	}
 
-	user, err := service.repo.Get(email)
+	user, err = service.CreateUser(ctx, email)
+	if errors.Is(err, ErrUserExists) {
+		//a concurrent request created the user first
+		return service.GetUser(ctx, email)
+	}
 	if err != nil {
 		return data.User{}, err
 	}
-
+	service.logger.Info("Create a new user previously not found", zap.String("user_email", email))
 	return user, nil
 }
 
-func (service *UserService) UpdateUser(userId primitive.ObjectID, userUpdateBody data.User) error {
-	return service.repo.Update(userId, userUpdateBody)
+func (service *UserService) GetUserByID(ctx context.Context, userId primitive.ObjectID) (data.User, error) {
+	return service.repo.GetByID(ctx, userId)
+}
+
+// ListUsers returns a page of users ordered by id, starting after the given id
+func (service *UserService) ListUsers(ctx context.Context, afterId primitive.ObjectID, limit int64) ([]data.User, error) {
+	return service.repo.List(ctx, afterId, limit)
+}
+
+func (service *UserService) SetQuota(ctx context.Context, email string, quotaBytes int64) error {
+	return service.repo.SetQuota(ctx, email, quotaBytes)
+}
+
+// GrantRole gives the user with the email a global role, creating the user if it has not signed in yet
+func (service *UserService) GrantRole(ctx context.Context, email string, role string) error {
+	if _, err := service.GetOrCreateUser(ctx, email); err != nil {
+		return err
+	}
+	if err := service.repo.AddRole(ctx, email, role); err != nil {
+		return err
+	}
+	service.logger.Info("Granted role to user", zap.String("user_email", email), zap.String("role", role))
+	return nil
 }
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetOrCreateUserCreatesOnFirstSight(t *testing.T) {
+	services := newTestServices(t, nil)
+	ctx := context.Background()
+
+	if _, err := services.userService.GetUser(ctx, "ada@example.com"); !errors.Is(err, data.ErrNotFound) {
+		t.Fatalf("GetUser before sign in: got %v, want ErrNotFound", err)
+	}
+
+	created, err := services.userService.GetOrCreateUser(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if created.ID.IsZero() || created.Email != "ada@example.com" || len(created.Files) != 0 {
+		t.Fatalf("GetOrCreateUser created %+v", created)
+	}
+
+	again, err := services.userService.GetOrCreateUser(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser again: %v", err)
+	}
+	if again.ID != created.ID {
+		t.Fatalf("GetOrCreateUser created a second user %s, first was %s", again.ID.Hex(), created.ID.Hex())
+	}
+}
+
+func TestCreateUserRejectsExistingEmail(t *testing.T) {
+	services := newTestServices(t, nil)
+	ctx := context.Background()
+
+	if _, err := services.userService.CreateUser(ctx, "ada@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := services.userService.CreateUser(ctx, "ada@example.com"); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("CreateUser with an existing email: got %v, want ErrUserExists", err)
+	}
+}
+
+func TestGetOrCreateUserConcurrentFirstSight(t *testing.T) {
+	services := newTestServices(t, nil)
+	ctx := context.Background()
+
+	const requests = 20
+	ids := make(chan primitive.ObjectID, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := services.userService.GetOrCreateUser(ctx, "ada@example.com")
+			if err != nil {
+				t.Errorf("GetOrCreateUser: %v", err)
+				return
+			}
+			ids <- user.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	var first primitive.ObjectID
+	for id := range ids {
+		if first.IsZero() {
+			first = id
+		}
+		if id != first {
+			t.Fatalf("concurrent sign ins created users %s and %s", first.Hex(), id.Hex())
+		}
+	}
+}
+
+func TestUpdateUserMergesFiles(t *testing.T) {
+	services := newTestServices(t, nil)
+	ctx := context.Background()
+	principal := services.signIn(t, "ada@example.com")
+
+	first, second, third := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	updates := [][]primitive.ObjectID{{first, second}, {second, third}, {}}
+	for _, files := range updates {
+		if err := services.userService.UpdateUser(ctx, principal.UserID, data.User{Files: files}); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+	}
+
+	user, err := services.userService.GetUser(ctx, principal.Email)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if len(user.Files) != 3 {
+		t.Fatalf("user files = %v, want the union of %v", user.Files, updates)
+	}
+	for _, id := range []primitive.ObjectID{first, second, third} {
+		if !containsId(user.Files, id) {
+			t.Fatalf("user files %v are missing %s", user.Files, id.Hex())
+		}
+	}
+}
+
+func TestUpdateUnknownUser(t *testing.T) {
+	services := newTestServices(t, nil)
+
+	err := services.userService.UpdateUser(context.Background(), primitive.NewObjectID(), data.User{})
+	if !errors.Is(err, data.ErrNotFound) {
+		t.Fatalf("UpdateUser of an unknown user: got %v, want ErrNotFound", err)
+	}
+}
+
+func containsId(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/Hitesh-Nagothu/vault-service/data"
+	"github.com/Hitesh-Nagothu/vault-service/identity"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrVersionNotFound = apperror.NotFound("version_not_found", "file version not found")
+	ErrVersionConflict = apperror.New(apperror.KindConflict, "version_conflict", "file was updated by another request, retry with the latest version")
+)
+
+// UpdateFile uploads the content as a new version of an existing file, the previous versions are kept.
+// The upload is rejected with ErrDigestMismatch if the content does not match a digest in expected.
+func (fs *FileService) UpdateFile(ctx context.Context, fileId string, content io.Reader, principal identity.Principal, expected Digests) (data.FileVersion, error) {
+	file, err := fs.getFile(ctx, fileId, principal, ActionWrite)
+	if err != nil {
+		return data.FileVersion{}, err
+	}
+
+	limitedContent := &limitedReader{reader: content, remaining: fs.maxFileSize}
+	chunks, size, digests, storeErr := fs.storeChunks(limitedContent, fs.computeMD5 || len(expected.MD5) > 0)
+	if storeErr != nil {
+		fs.releaseContent(chunks)
+		if errors.Is(storeErr, ErrFileTooLarge) {
+			fs.logger.Error("File exceeds the size limit", zap.String("file_id", fileId), zap.Int64("max_size", fs.maxFileSize))
+			return data.FileVersion{}, fs.errFileTooLarge()
+		}
+		fs.logger.Error("Failed to store file content", zap.String("file_id", fileId), zap.Error(storeErr))
+		return data.FileVersion{}, apperror.StorageUnavailable("something went wrong storing the file", storeErr)
+	}
+
+	if err := digests.verify(expected); err != nil {
+		fs.logger.Error("Uploaded content does not match its digest", zap.String("file_id", fileId), zap.String("sha256", digests.SHA256), zap.String("md5", digests.MD5))
+		fs.releaseContent(chunks)
+		return data.FileVersion{}, err
+	}
+
+	if err := fs.checkQuota(ctx, fileOwner(file, principal), size); err != nil {
+		fs.releaseContent(chunks)
+		return data.FileVersion{}, err
+	}
+
+	var newVersion data.FileVersion
+	txErr := fs.unitOfWork.Do(ctx, func(txCtx context.Context) error {
+		chunkIds, err := fs.recordChunks(txCtx, chunks)
+		if err != nil {
+			return err
+		}
+
+		newVersion = data.FileVersion{
+			Number:     currentVersion(file).Number + 1,
+			ChunkIDs:   chunkIds,
+			Size:       size,
+			Hash:       digests.SHA256,
+			MD5:        digests.MD5,
+			UploadedBy: principal.Email,
+			CreatedAt:  time.Now(),
+		}
+		return fs.repo.SetVersions(txCtx, file.ID, file.Version, append(fileVersions(file), newVersion))
+	})
+	if txErr != nil {
+		fs.releaseContent(chunks)
+		if errors.Is(txErr, data.ErrVersionConflict) {
+			return data.FileVersion{}, ErrVersionConflict
+		}
+		fs.logger.Error("Failed to record the new file version", zap.String("file_id", fileId), zap.Error(txErr))
+		return data.FileVersion{}, apperror.Internal("something went wrong processing the file", txErr)
+	}
+
+	fs.logger.Info("File version upload successful", zap.String("file_id", fileId), zap.Int("version", newVersion.Number))
+	return newVersion, nil
+}
+
+// ListVersions returns the version history of the file, oldest first
+func (fs *FileService) ListVersions(ctx context.Context, fileId string, principal identity.Principal) ([]data.FileVersion, error) {
+	file, err := fs.GetFile(ctx, fileId, principal)
+	if err != nil {
+		return nil, err
+	}
+	return fileVersions(file), nil
+}
+
+// GetVersion returns the given version of the file, 0 being the current one
+func (fs *FileService) GetVersion(file data.File, number int) (data.FileVersion, error) {
+	if number == 0 {
+		return currentVersion(file), nil
+	}
+	for _, version := range fileVersions(file) {
+		if version.Number == number {
+			return version, nil
+		}
+	}
+	return data.FileVersion{}, ErrVersionNotFound
+}
+
+// RollbackFile makes an earlier version current again. The rollback is recorded as a new version pointing at the
+// earlier version's content, so the versions in between stay available.
+func (fs *FileService) RollbackFile(ctx context.Context, fileId string, number int, principal identity.Principal) (data.FileVersion, error) {
+	file, err := fs.getFile(ctx, fileId, principal, ActionWrite)
+	if err != nil {
+		return data.FileVersion{}, err
+	}
+
+	target, err := fs.GetVersion(file, number)
+	if err != nil {
+		return data.FileVersion{}, err
+	}
+
+	//the rollback is a new version, usage counts it like any other
+	if err := fs.checkQuota(ctx, fileOwner(file, principal), target.Size); err != nil {
+		return data.FileVersion{}, err
+	}
+
+	newVersion := data.FileVersion{
+		Number:     currentVersion(file).Number + 1,
+		ChunkIDs:   target.ChunkIDs,
+		Size:       target.Size,
+		Hash:       target.Hash,
+		MD5:        target.MD5,
+		UploadedBy: principal.Email,
+		CreatedAt:  time.Now(),
+	}
+	//the new version holds its own references on the restored content
+	err = fs.unitOfWork.Do(ctx, func(txCtx context.Context) error {
+		if err := fs.chunkService.AddReferences(txCtx, newVersion.ChunkIDs); err != nil {
+			return err
+		}
+		return fs.repo.SetVersions(txCtx, file.ID, file.Version, append(fileVersions(file), newVersion))
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrVersionConflict) {
+			return data.FileVersion{}, ErrVersionConflict
+		}
+		fs.logger.Error("Failed to roll back file", zap.String("file_id", fileId), zap.Int("version", number), zap.Error(err))
+		return data.FileVersion{}, apperror.Internal("something went wrong rolling back the file", err)
+	}
+
+	fs.logger.Info("File rolled back", zap.String("file_id", fileId), zap.Int("to_version", number), zap.Int("version", newVersion.Number))
+	return newVersion, nil
+}
+
+// fileVersions returns the version history, files uploaded before versioning get their content as version 1
+func fileVersions(file data.File) []data.FileVersion {
+	if len(file.Versions) > 0 {
+		return file.Versions
+	}
+	return []data.FileVersion{
+		{
+			Number:    1,
+			ChunkIDs:  file.ChunkIDs,
+			Size:      file.Size,
+			Hash:      file.Hash,
+			MD5:       file.MD5,
+			CreatedAt: file.CreatedAt,
+		},
+	}
+}
+
+func currentVersion(file data.File) data.FileVersion {
+	versions := fileVersions(file)
+	return versions[len(versions)-1]
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+// FileSystemStore keeps blobs as files under a root directory, addressed by their SHA-256 hex digest
+type FileSystemStore struct {
+	root   string
+	logger *zap.Logger
+}
+
+func NewFileSystemStore(logger *zap.Logger, root string) (*FileSystemStore, error) {
+	if len(root) == 0 {
+		return nil, fmt.Errorf("storage path is required for the filesystem backend")
+	}
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &FileSystemStore{
+		root:   root,
+		logger: logger,
+	}, nil
+}
+
+func (store *FileSystemStore) Put(content io.Reader) (string, error) {
+	//write to a temp file first, the address is only known once all the content is hashed
+	tmp, err := os.CreateTemp(store.root, "upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	_, copyErr := io.Copy(io.MultiWriter(tmp, hasher), content)
+	closeErr := tmp.Close()
+	if copyErr != nil {
+		return "", fmt.Errorf("failed to write blob: %w", copyErr)
+	}
+	if closeErr != nil {
+		return "", fmt.Errorf("failed to write blob: %w", closeErr)
+	}
+
+	address := hex.EncodeToString(hasher.Sum(nil))
+	blobPath := store.path(address)
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0o750); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), blobPath); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	return address, nil
+}
+
+func (store *FileSystemStore) Get(ctx context.Context, address string) (io.ReadCloser, error) {
+	blobPath, err := store.existingPath(address)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return file, nil
+}
+
+func (store *FileSystemStore) Stat(address string) (BlobInfo, error) {
+	blobPath, err := store.existingPath(address)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info, err := os.Stat(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return BlobInfo{}, ErrBlobNotFound
+		}
+		return BlobInfo{}, fmt.Errorf("failed to stat blob: %w", err)
+	}
+	return BlobInfo{Address: address, Size: info.Size()}, nil
+}
+
+func (store *FileSystemStore) Delete(address string) error {
+	blobPath, err := store.existingPath(address)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrBlobNotFound
+		}
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+func (store *FileSystemStore) Exists(address string) (bool, error) {
+	_, err := store.Stat(address)
+	if err == ErrBlobNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// List walks the blob directories, temp files of uploads in progress are skipped
+func (store *FileSystemStore) List(ctx context.Context) ([]string, error) {
+	addresses := []string{}
+	err := filepath.WalkDir(store.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		address := entry.Name()
+		if _, err := store.existingPath(address); err == nil && store.path(address) == path {
+			addresses = append(addresses, address)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	return addresses, nil
+}
+
+// blobs are fanned out by the first two hex characters to keep directories small
+func (store *FileSystemStore) path(address string) string {
+	return filepath.Join(store.root, address[:2], address)
+}
+
+// existingPath validates the address before it is used to build a path, so it cannot escape the root
+func (store *FileSystemStore) existingPath(address string) (string, error) {
+	decoded, err := hex.DecodeString(address)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", ErrBlobNotFound
+	}
+	return store.path(address), nil
+}
+
+// Check writes and removes a probe file, which fails when the directory is missing, read only or out of space
+func (store *FileSystemStore) Check(ctx context.Context) error {
+	probe, err := os.CreateTemp(store.root, "probe-*")
+	if err != nil {
+		return fmt.Errorf("failed to create probe file: %w", err)
+	}
+	defer os.Remove(probe.Name())
+
+	_, writeErr := probe.Write([]byte("probe"))
+	closeErr := probe.Close()
+	if writeErr != nil {
+		return fmt.Errorf("failed to write probe file: %w", writeErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write probe file: %w", closeErr)
+	}
+	return nil
+}
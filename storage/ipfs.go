@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+	"go.uber.org/zap"
+)
+
+const ipfsRequestTimeout = 30 * time.Second // also how long a read may wait on the node before Get gives up
+
+// IPFSStore keeps blobs pinned on an IPFS node, addressed by CID
+type IPFSStore struct {
+	api    *shell.Shell
+	logger *zap.Logger
+}
+
+func NewIPFSStore(logger *zap.Logger, url string) *IPFSStore {
+	return &IPFSStore{
+		api:    shell.NewShell(url),
+		logger: logger,
+	}
+}
+
+// Put adds content to the IPFS network and returns the CID.
+func (store *IPFSStore) Put(content io.Reader) (string, error) {
+	cid, err := store.api.Add(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to add content to IPFS: %w", err)
+	}
+	return cid, nil
+}
+
+// Get streams content from the IPFS network using the given CID. The request is cancelled with the context, and
+// when the node sends nothing for ipfsRequestTimeout, e.g. while it searches the network for a missing block.
+func (store *IPFSStore) Get(ctx context.Context, cid string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stall := time.AfterFunc(ipfsRequestTimeout, cancel)
+
+	response, err := store.api.Request("cat", cid).Send(ctx)
+	stall.Stop()
+	if err == nil && response.Error != nil {
+		err = response.Error
+		response.Output.Close()
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to retrieve content from IPFS: %w", err)
+	}
+	return &ipfsReader{output: response.Output, stall: stall, cancel: cancel}, nil
+}
+
+// ipfsReader cancels the cat request when a read waits on the node for longer than ipfsRequestTimeout. The timer
+// only runs during reads, a slow consumer does not count against it.
+type ipfsReader struct {
+	output io.ReadCloser
+	stall  *time.Timer
+	cancel context.CancelFunc
+}
+
+func (reader *ipfsReader) Read(p []byte) (int, error) {
+	reader.stall.Reset(ipfsRequestTimeout)
+	n, err := reader.output.Read(p)
+	reader.stall.Stop()
+	return n, err
+}
+
+func (reader *ipfsReader) Close() error {
+	reader.cancel()
+	return reader.output.Close()
+}
+
+func (store *IPFSStore) Stat(cid string) (BlobInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ipfsRequestTimeout)
+	defer cancel()
+
+	stat, err := store.api.FilesStat(ctx, "/ipfs/"+cid)
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to stat content on IPFS: %w", err)
+	}
+	return BlobInfo{Address: cid, Size: int64(stat.Size)}, nil
+}
+
+// Delete unpins the content, leaving it to the IPFS node's own garbage collection
+func (store *IPFSStore) Delete(cid string) error {
+	err := store.api.Unpin(cid)
+	if err != nil {
+		if strings.Contains(err.Error(), "not pinned") {
+			return ErrBlobNotFound
+		}
+		return fmt.Errorf("failed to unpin content from IPFS: %w", err)
+	}
+	return nil
+}
+
+// Exists reports whether the content is pinned on the node, not whether it is reachable somewhere on the network
+func (store *IPFSStore) Exists(cid string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ipfsRequestTimeout)
+	defer cancel()
+
+	var pins struct {
+		Keys map[string]shell.PinInfo
+	}
+	err := store.api.Request("pin/ls", cid).Option("type", "recursive").Exec(ctx, &pins)
+	if err != nil {
+		if strings.Contains(err.Error(), "not pinned") {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to list pins on IPFS: %w", err)
+	}
+	return len(pins.Keys) > 0, nil
+}
+
+// List returns the CIDs pinned on the node. Pins made by anything else sharing the node are listed too.
+func (store *IPFSStore) List(ctx context.Context) ([]string, error) {
+	var pins struct {
+		Keys map[string]shell.PinInfo
+	}
+	err := store.api.Request("pin/ls").Option("type", "recursive").Exec(ctx, &pins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pins on IPFS: %w", err)
+	}
+
+	cids := make([]string, 0, len(pins.Keys))
+	for cid := range pins.Keys {
+		cids = append(cids, cid)
+	}
+	return cids, nil
+}
+
+// Check asks the node for its identity, which fails when the node is down or unreachable
+func (store *IPFSStore) Check(ctx context.Context) error {
+	var id struct {
+		ID string
+	}
+	if err := store.api.Request("id").Exec(ctx, &id); err != nil {
+		return fmt.Errorf("failed to reach IPFS node: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// MemoryStore keeps blobs in process memory, addressed by their SHA-256 hex digest. Content is lost on restart.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	blobs  map[string][]byte
+	logger *zap.Logger
+}
+
+func NewMemoryStore(logger *zap.Logger) *MemoryStore {
+	return &MemoryStore{
+		blobs:  make(map[string][]byte),
+		logger: logger,
+	}
+}
+
+func (store *MemoryStore) Put(content io.Reader) (string, error) {
+	blob, err := io.ReadAll(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to read blob: %w", err)
+	}
+	sum := sha256.Sum256(blob)
+	address := hex.EncodeToString(sum[:])
+
+	store.mu.Lock()
+	store.blobs[address] = blob
+	store.mu.Unlock()
+	return address, nil
+}
+
+func (store *MemoryStore) Get(ctx context.Context, address string) (io.ReadCloser, error) {
+	store.mu.RLock()
+	blob, ok := store.blobs[address]
+	store.mu.RUnlock()
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(blob)), nil
+}
+
+func (store *MemoryStore) Stat(address string) (BlobInfo, error) {
+	store.mu.RLock()
+	blob, ok := store.blobs[address]
+	store.mu.RUnlock()
+	if !ok {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	return BlobInfo{Address: address, Size: int64(len(blob))}, nil
+}
+
+func (store *MemoryStore) Delete(address string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.blobs[address]; !ok {
+		return ErrBlobNotFound
+	}
+	delete(store.blobs, address)
+	return nil
+}
+
+func (store *MemoryStore) Exists(address string) (bool, error) {
+	store.mu.RLock()
+	_, ok := store.blobs[address]
+	store.mu.RUnlock()
+	return ok, nil
+}
+
+func (store *MemoryStore) List(ctx context.Context) ([]string, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	addresses := make([]string, 0, len(store.blobs))
+	for address := range store.blobs {
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// Check always succeeds, memory is there as long as the process is
+func (store *MemoryStore) Check(ctx context.Context) error {
+	return nil
+}
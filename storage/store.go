@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Hitesh-Nagothu/vault-service/apperror"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var ErrBlobNotFound = apperror.NotFound("blob_not_found", "blob not found")
+
+// BlobStore persists immutable blobs and addresses them by their content
+type BlobStore interface {
+	// Put stores the content and returns its content address
+	Put(content io.Reader) (string, error)
+	// Get returns a reader for the content at the address, reading stops once the context is done. The caller is
+	// responsible for closing it.
+	Get(ctx context.Context, address string) (io.ReadCloser, error)
+	Stat(address string) (BlobInfo, error)
+	Delete(address string) error
+	Exists(address string) (bool, error)
+	// List returns the address of every blob in the store, for garbage collection
+	List(ctx context.Context) ([]string, error)
+	// Check verifies the backend is reachable and usable, for readiness probes
+	Check(ctx context.Context) error
+}
+
+type BlobInfo struct {
+	Address string
+	Size    int64
+}
+
+// NewBlobStore builds the blob store selected by the storage.backend config key
+func NewBlobStore(logger *zap.Logger) (BlobStore, error) {
+	backend := viper.GetString("storage.backend")
+	switch backend {
+	case "ipfs":
+		return NewIPFSStore(logger, viper.GetString("ipfs.url")+viper.GetString("ipfs.port")), nil
+	case "filesystem":
+		return NewFileSystemStore(logger, viper.GetString("storage.path"))
+	case "memory":
+		return NewMemoryStore(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
@@ -57,3 +57,12 @@ func UnionOfIds(existing []primitive.ObjectID, new []primitive.ObjectID) []primi
 
 	return union
 }
+
+func ContainsId(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}